@@ -3,108 +3,1833 @@ package lrumap
 import (
 	"bytes"
 	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+var (
+	// ErrDuplicateKey is returned when data object with the same key already
+	// exists in the table.
+	ErrDuplicateKey = errors.New("Duplicated key")
+	// ErrTTLTooLarge is returned when TTL is over maxTick of the table.
+	ErrTTLTooLarge = errors.New("TTL is over maxTick")
+	// ErrChainTooLong is returned when a bucket already has the limit of
+	// WithMaxChainLength.
+	ErrChainTooLong = errors.New("Bucket chain is too long")
+	// ErrInvalidMaxTick is returned when maxTick is 0 that can not schedule
+	// any data object to expire.
+	ErrInvalidMaxTick = errors.New("maxTick must be larger than 0")
+	// ErrInvalidFrames is returned by NewWithResolution when number of
+	// frames is not a multiple of maxTick+1.
+	ErrInvalidFrames = errors.New("frames must be a multiple of maxTick+1")
+	// ErrTooHeavy is returned when weight of data object is over the limit
+	// of WithMaxWeight.
+	ErrTooHeavy = errors.New("Weight of data object is over maxWeight")
+)
+
+// EvictReason describes why data object is removed from LruMap table.
+type EvictReason int
+
+const (
+	// ExpiryReason means data object is removed because TTL expired.
+	ExpiryReason EvictReason = iota
+	// DeleteReason means data object is removed by Delete.
+	DeleteReason
+	// CapacityReason means data object is removed to fit in the limit of
+	// WithMaxEntries.
+	CapacityReason
+)
+
+// Weighted is an optional interface of LruData for WithMaxWeight. Data
+// object that does not implement Weighted has weight 1.
+type Weighted interface {
+	Weight() int
+}
+
+// Cloner is an optional interface of LruData for GetClone. Clone must
+// return a copy of the data object that does not share mutable state with
+// the original.
+type Cloner interface {
+	Clone() LruData
+}
+
 // LruData is an interface for data object for LruMap.
-// Key() must returns unique key that is byte slice in the table.
+// Key() must returns unique key that is byte slice in the table. The key is
+// copied when the data object is inserted, so modifying the byte slice after
+// insertion does not affect lookup.
 type LruData interface {
 	Key() *[]byte
 }
 
-// LruMap is a main structure of the library. A developer accesses
-// data object in the table via LruMap instance.
-type LruMap struct {
-	table   map[hashValue]*bucket
-	frames  []frame
-	current tick
-	maxTick tick
-	count   int
+// LruMap is a main structure of the library. A developer accesses
+// data object in the table via LruMap instance.
+type LruMap struct {
+	table   map[hashValue]*bucket
+	frames  []frame
+	current tick
+	maxTick tick
+	count   atomic.Int64
+	sliding bool
+
+	// persistent has nodes that never expire
+	persistent frame
+	// sparse has only non-empty frames by slot instead of frames if
+	// WithSparseFrames is given
+	sparse map[tick]*frame
+	// lanes is number of frames for each tick given by NewWithResolution,
+	// and phase is the next lane of current tick to be pruned by PruneStep
+	lanes tick
+	phase tick
+
+	maxEntries int
+	recent     recentList
+	maxWeight  int
+	weight     int
+	stats      counters
+	hash       func(key *[]byte) hashValue
+	capacity   int
+	name       string
+
+	maxChainLength int
+	keyEqual       func(a, b *[]byte) bool
+	clampTTL       bool
+
+	secondary      func(LruData) string
+	secondaryIndex map[string]map[*node]struct{}
+
+	// idleUsed is set by PutWithIdle so that Get reschedules data objects
+	idleUsed atomic.Bool
+
+	onEvict       func(LruData)
+	onEvictReason func(LruData, EvictReason)
+	evictOnDelete bool
+	evictCh       chan LruData
+	// deferEvict makes notifyEvict keep removed data objects in
+	// pendingEvicts instead of calling the callbacks, so that SyncLruMap
+	// calls them after releasing lock
+	deferEvict    bool
+	pendingEvicts []pendingEvict
+
+	jitter       tick
+	jitterSource func(n uint64) uint64
+
+	clock      Clock
+	origin     time.Time
+	resolution time.Duration
+}
+
+// New is a constructor of LruMap. maxTick 0 is accepted for compatibility,
+// but only data objects that never expire can be put into the LruMap. Use
+// NewWithError to reject it.
+func New(maxTick tick, options ...Option) *LruMap {
+	return newLruMap(maxTick, 1, options)
+}
+
+// NewWithResolution is a constructor of LruMap having frames finer than
+// tick. Each tick is split into frames/(maxTick+1) lanes, and PruneStep
+// advances the wheel by one lane. Data object put in a lane is scheduled
+// to the same lane of the tick after its TTL, so data objects put between
+// PruneStep calls land in distinct frames and the pruning work of a tick is
+// spread over the lanes. Prune still advances whole ticks. It returns
+// ErrInvalidFrames if frames is not a positive multiple of maxTick+1.
+func NewWithResolution(maxTick tick, frames int, options ...Option) (*LruMap, error) {
+	if frames <= 0 || tick(frames)%(maxTick+1) != 0 {
+		return nil, ErrInvalidFrames
+	}
+	return newLruMap(maxTick, tick(frames)/(maxTick+1), options), nil
+}
+
+func newLruMap(maxTick, lanes tick, options []Option) *LruMap {
+	lruMap := LruMap{
+		table:   map[hashValue]*bucket{},
+		maxTick: maxTick,
+		lanes:   lanes,
+		hash:    fnvHash,
+
+		jitterSource: randJitter,
+	}
+	for _, opt := range options {
+		opt(&lruMap)
+	}
+	if lruMap.sparse == nil {
+		lruMap.frames = make([]frame, (maxTick+1)*lanes)
+	}
+	return &lruMap
+}
+
+// NewWithError is a constructor of LruMap as New, but returns
+// ErrInvalidMaxTick if maxTick is 0.
+func NewWithError(maxTick tick, options ...Option) (*LruMap, error) {
+	if maxTick == 0 {
+		return nil, ErrInvalidMaxTick
+	}
+	return New(maxTick, options...), nil
+}
+
+// Put inserts data object into LruMap table.
+// LruMap does not allow to insert object with duplicated key.
+// Data object with ttl 0 never expires and is not pruned by Prune.
+func (x *LruMap) Put(obj LruData, ttl tick) error {
+	return x.put(obj.Key(), obj, ttl, false)
+}
+
+// PutOptions changes behavior of PutWithOptions.
+type PutOptions struct {
+	// Overwrite replaces existing data object with the same key and resets
+	// its TTL instead of returning ErrDuplicateKey.
+	Overwrite bool
+}
+
+// PutWithOptions inserts data object into LruMap table as Put. If
+// opts.Overwrite is true, it works as Upsert.
+func (x *LruMap) PutWithOptions(obj LruData, ttl tick, opts PutOptions) error {
+	return x.put(obj.Key(), obj, ttl, opts.Overwrite)
+}
+
+// put inserts data object with the key that may be different from Key() of
+// the data object. If overwrite is true, data object of the existing key is
+// replaced and its TTL is reset by ttl.
+func (x *LruMap) put(key *[]byte, obj LruData, ttl tick, overwrite bool) error {
+	ttl, ok := x.validTTL(ttl)
+	if !ok {
+		return ErrTTLTooLarge
+	}
+	ttl = x.addJitter(ttl)
+	if x.tooHeavy(obj) {
+		return ErrTooHeavy
+	}
+
+	if overwrite {
+		if existing := x.lookup(key); existing != nil {
+			x.replaceData(existing, obj)
+			x.stats.puts.Add(1)
+			x.reschedule(existing, ttl)
+			x.touch(existing)
+			x.evictOverCapacity(existing)
+			return nil
+		}
+	}
+
+	return x.insert(key, obj, x.current, ttl)
+}
+
+// validTTL returns ttl and true if ttl can be scheduled. If ttl is over
+// maxTick, it returns false, or maxTick and true with WithClampTTL.
+func (x *LruMap) validTTL(ttl tick) (tick, bool) {
+	if ttl <= x.maxTick {
+		return ttl, true
+	}
+	if x.clampTTL {
+		return x.maxTick, true
+	}
+	return ttl, false
+}
+
+// addJitter adds random offset in range of [0, jitter] to ttl. The result
+// is capped at maxTick, and ttl 0 is not changed.
+func (x *LruMap) addJitter(ttl tick) tick {
+	if x.jitter == 0 || ttl == 0 {
+		return ttl
+	}
+
+	// Offset over maxTick is capped anyway
+	max := x.jitter
+	if max > x.maxTick {
+		max = x.maxTick
+	}
+
+	offset := tick(x.jitterSource(uint64(max) + 1))
+	if offset > x.maxTick-ttl {
+		return x.maxTick
+	}
+	return ttl + offset
+}
+
+// insertRemaining inserts data object that expires after remain ticks.
+// Unlike put, remain 0 means the data object expires at current tick. If
+// current tick is 0, it expires at the next tick because no earlier tick
+// can be scheduled.
+func (x *LruMap) insertRemaining(key *[]byte, obj LruData, remain tick) error {
+	if remain > 0 {
+		return x.insert(key, obj, x.current, remain)
+	}
+	if x.current == 0 {
+		return x.insert(key, obj, 0, 1)
+	}
+	return x.insert(key, obj, x.current-1, 1)
+}
+
+// insert puts a new node scheduled at latest+ttl into the table.
+func (x *LruMap) insert(key *[]byte, obj LruData, latest, ttl tick) error {
+	if x.tooHeavy(obj) {
+		return ErrTooHeavy
+	}
+
+	hv := x.hash(key)
+	bkt := x.getBucket(hv)
+	if x.chainFull(bkt) && bkt.search(key) == nil {
+		return ErrChainTooLong
+	}
+
+	newNode := allocNode()
+	newNode.key = copyKey(*key)
+	newNode.data = obj
+	newNode.hv = hv
+	newNode.latest = latest
+	newNode.lane = x.phase
+	newNode.ttl = ttl
+	if err := bkt.insert(newNode); err != nil {
+		freeNode(newNode)
+		return err
+	}
+
+	x.schedule(newNode)
+	newNode.weight = weightOf(obj)
+	x.weight += newNode.weight
+	x.indexNode(newNode)
+
+	x.count.Add(1)
+	x.stats.puts.Add(1)
+	x.touch(newNode)
+	x.evictOverCapacity(newNode)
+
+	return nil
+}
+
+// PutWithIdle inserts data object that expires after idle ticks since the
+// last access by Get or Touch, but no later than ttl ticks from now. ttl 0
+// means the data object has no hard TTL and expires only by idle, and idle
+// 0 works as Put. Once PutWithIdle is called, Get of SyncLruMap takes write
+// lock to reschedule data objects. SetTTL and overwriting the data object
+// replace the time-to-idle with a plain TTL.
+func (x *LruMap) PutWithIdle(obj LruData, ttl, idle tick) error {
+	if idle == 0 {
+		return x.Put(obj, ttl)
+	}
+	ttl, ok := x.validTTL(ttl)
+	if !ok {
+		return ErrTTLTooLarge
+	}
+	if idle, ok = x.validTTL(idle); !ok {
+		return ErrTTLTooLarge
+	}
+
+	first := idle
+	if ttl > 0 && ttl < idle {
+		first = ttl
+	}
+	if err := x.insert(obj.Key(), obj, x.current, first); err != nil {
+		return err
+	}
+
+	x.idleUsed.Store(true)
+	if n := x.lookup(obj.Key()); n != nil {
+		n.idle = idle
+		if ttl > 0 {
+			n.deadline = x.current + ttl
+		}
+	}
+	return nil
+}
+
+// PutItem is a pair of data object and TTL for PutBatch.
+type PutItem struct {
+	Obj LruData
+	TTL tick
+}
+
+// PutBatch inserts all items into LruMap table. It returns errors aligned
+// with items, and the error is nil if the item is inserted successfully.
+// A failed item does not stop insertion of following items.
+func (x *LruMap) PutBatch(items []PutItem) []error {
+	errs := make([]error, len(items))
+	for i := range items {
+		errs[i] = x.Put(items[i].Obj, items[i].TTL)
+	}
+	return errs
+}
+
+// PutAt inserts data object that expires at absolute tick `expireAt`.
+// expireAt must be in range of (current tick, current tick + maxTick]. The
+// data object expires exactly at expireAt without jitter of WithJitter.
+func (x *LruMap) PutAt(obj LruData, expireAt tick) error {
+	if expireAt <= x.current {
+		return fmt.Errorf("expireAt %d is not after current tick %d", expireAt, x.current)
+	}
+	if expireAt > x.current+x.maxTick {
+		return fmt.Errorf("%w: expireAt %d is over horizon %d", ErrTTLTooLarge, expireAt, x.current+x.maxTick)
+	}
+
+	return x.insert(obj.Key(), obj, x.current, expireAt-x.current)
+}
+
+// Upsert inserts data object into LruMap table. If data object with the
+// same key already exists, it is replaced with obj and TTL is reset by ttl.
+func (x *LruMap) Upsert(obj LruData, ttl tick) error {
+	return x.put(obj.Key(), obj, ttl, true)
+}
+
+// GetOrPut returns existing data object with the same key as obj and false
+// if it is loaded from the table. Otherwise GetOrPut inserts obj with ttl
+// and returns obj and true as stored. If ttl is over maxTick or obj is
+// heavier than the limit of WithMaxWeight, and the key does not exist,
+// GetOrPut returns nil and false without inserting obj. It also returns nil
+// and false if obj can not be inserted because of WithMaxChainLength.
+func (x *LruMap) GetOrPut(obj LruData, ttl tick) (LruData, bool) {
+	ttl, ok := x.validTTL(ttl)
+	if !ok || x.tooHeavy(obj) {
+		return x.Get(obj.Key()), false
+	}
+
+	hv := x.hash(obj.Key())
+	bkt := x.getBucket(hv)
+	if x.chainFull(bkt) && bkt.search(obj.Key()) == nil {
+		return nil, false
+	}
+
+	newNode := allocNode()
+	newNode.key = *obj.Key()
+	newNode.data = obj
+	newNode.hv = hv
+	newNode.latest = x.current
+	newNode.lane = x.phase
+	newNode.ttl = ttl
+	if existing := bkt.searchOrInsert(newNode); existing != nil {
+		freeNode(newNode)
+		if x.sliding || existing.idle > 0 {
+			x.extend(existing)
+		}
+		x.touch(existing)
+		return existing.data, false
+	}
+
+	// Key is copied after insertion not to allocate for existing key.
+	newNode.key = copyKey(newNode.key)
+	newNode.ttl = x.addJitter(ttl)
+	x.schedule(newNode)
+	newNode.weight = weightOf(obj)
+	x.weight += newNode.weight
+	x.indexNode(newNode)
+	x.count.Add(1)
+	x.stats.puts.Add(1)
+	x.touch(newNode)
+	x.evictOverCapacity(newNode)
+
+	return obj, true
+}
+
+// GetOrCompute returns data object of the key if exists. Otherwise it calls
+// loader, inserts the returned data object with the key and ttl, and returns
+// it. If loader returns nil, nothing is inserted. If the loaded data object
+// can not be inserted because ttl is over maxTick, it is returned without
+// being stored.
+func (x *LruMap) GetOrCompute(key *[]byte, ttl tick, loader func() LruData) LruData {
+	if d := x.Get(key); d != nil {
+		return d
+	}
+
+	obj := loader()
+	if obj != nil {
+		x.put(key, obj, ttl, false)
+	}
+	return obj
+}
+
+// Get returns data object if exists.
+func (x *LruMap) Get(key *[]byte) LruData {
+	data, _ := x.Lookup(key)
+	return data
+}
+
+// GetClone works as Get, but returns copy of the data object by Clone if it
+// implements Cloner, so that modifying the returned data object does not
+// affect the table. Otherwise, the data object itself is returned.
+func (x *LruMap) GetClone(key *[]byte) LruData {
+	data := x.Get(key)
+	if c, ok := data.(Cloner); ok {
+		return c.Clone()
+	}
+	return data
+}
+
+// Lookup works as Get, but also returns true if the key exists. Data object
+// that is nil of a concrete type is returned with true.
+func (x *LruMap) Lookup(key *[]byte) (LruData, bool) {
+	x.advance()
+
+	searched := x.lookup(key)
+	if searched == nil {
+		x.stats.misses.Add(1)
+		return nil, false
+	}
+	x.stats.hits.Add(1)
+	if x.sliding || searched.idle > 0 {
+		x.extend(searched)
+	}
+	x.touch(searched)
+	return searched.data, true
+}
+
+// GetMulti returns data objects of keys. The result is aligned with keys,
+// and nil is set for a key that does not exist.
+func (x *LruMap) GetMulti(keys []*[]byte) []LruData {
+	res := make([]LruData, len(keys))
+	for i, key := range keys {
+		res[i] = x.Get(key)
+	}
+	return res
+}
+
+// Contains returns true if data object with the key exists.
+func (x *LruMap) Contains(key *[]byte) bool {
+	return x.lookup(key) != nil
+}
+
+// RemainingTTL returns number of ticks until the data object with the key
+// is pruned. The bool value is false if the key does not exist. It also
+// returns 0 for data object that never expires.
+func (x *LruMap) RemainingTTL(key *[]byte) (tick, bool) {
+	target := x.lookup(key)
+	if target == nil {
+		return 0, false
+	}
+
+	expireAt := target.expireAt()
+	if target.ttl == 0 || expireAt < x.current {
+		return 0, true
+	}
+	return expireAt - x.current, true
+}
+
+// PeekExpiry returns absolute tick at which data object of the key is
+// pruned. The bool value is false if the key does not exist. It returns 0
+// for data object that never expires.
+func (x *LruMap) PeekExpiry(key *[]byte) (tick, bool) {
+	target := x.lookup(key)
+	if target == nil {
+		return 0, false
+	}
+	if target.ttl == 0 {
+		return 0, true
+	}
+	return target.expireAt(), true
+}
+
+// SetTTL reschedules data object of the key to expire after ttl from
+// current tick without changing the data object. The ttl also replaces the
+// original TTL used by sliding expiration, and ttl 0 makes the data object
+// never expire. It returns false if the key does not exist or ttl is over
+// maxTick.
+func (x *LruMap) SetTTL(key *[]byte, ttl tick) bool {
+	ttl, ok := x.validTTL(ttl)
+	if !ok {
+		return false
+	}
+
+	target := x.lookup(key)
+	if target == nil {
+		return false
+	}
+
+	x.reschedule(target, ttl)
+	return true
+}
+
+// CompareAndSwap replaces data object of the key with newData and resets
+// TTL by ttl only if the current data object is identical to expected. It
+// returns true if the data object is replaced. Key() of newData must be
+// same as Key() of the current data object, compared as WithKeyEqual if
+// given. It returns false without replacing if newData is heavier than the
+// limit of WithMaxWeight.
+func (x *LruMap) CompareAndSwap(key *[]byte, expected, newData LruData, ttl tick) bool {
+	return x.CompareAndSwapFunc(key, expected, newData, ttl, func(current, expected LruData) bool {
+		return current == expected
+	})
+}
+
+// CompareAndSwapFunc works as CompareAndSwap, but compares the current data
+// object and expected by equal.
+func (x *LruMap) CompareAndSwapFunc(key *[]byte, expected, newData LruData, ttl tick, equal func(current, expected LruData) bool) bool {
+	ttl, ok := x.validTTL(ttl)
+	if !ok || x.tooHeavy(newData) {
+		return false
+	}
+
+	target := x.lookup(key)
+	if target == nil {
+		return false
+	}
+	if !x.sameKey(target.data.Key(), newData.Key()) || !equal(target.data, expected) {
+		return false
+	}
+
+	x.replaceData(target, newData)
+	x.stats.puts.Add(1)
+	x.reschedule(target, ttl)
+	x.touch(target)
+	x.evictOverCapacity(target)
+	return true
+}
+
+// Update replaces data object of the key with one returned by mutate that
+// receives the current data object, and returns false if the key does not
+// exist. The data object is replaced in place without changing its
+// expiration and LRU order. Key() of the returned data object must be same
+// as Key() of the current data object, compared as WithKeyEqual if given,
+// and Update panics otherwise. If the returned data object is heavier than
+// the limit of WithMaxWeight, the current data object is kept and Update
+// returns false.
+func (x *LruMap) Update(key *[]byte, mutate func(LruData) LruData) bool {
+	target := x.lookup(key)
+	if target == nil {
+		return false
+	}
+
+	prev := copyKey(*target.data.Key())
+	obj := mutate(target.data)
+	if !x.sameKey(&prev, obj.Key()) {
+		panic(fmt.Sprintf("Key %q is changed to %q by mutate of Update", prev, *obj.Key()))
+	}
+	if x.tooHeavy(obj) {
+		return false
+	}
+
+	x.replaceData(target, obj)
+	x.evictOverCapacity(target)
+	return true
+}
+
+// Touch reschedules data object of the key to expire after its original
+// TTL from current tick without changing the data object. It returns false
+// if the key does not exist.
+func (x *LruMap) Touch(key *[]byte) bool {
+	target := x.lookup(key)
+	if target == nil {
+		return false
+	}
+
+	x.extend(target)
+	x.touch(target)
+	return true
+}
+
+// Delete removes data object from LruMap table and returns it.
+// If the key does not exist, Delete returns nil.
+func (x *LruMap) Delete(key *[]byte) LruData {
+	target := x.lookup(key)
+	if target == nil {
+		return nil
+	}
+	return x.deleteNode(target)
+}
+
+// DeleteKeys removes data objects of keys and returns number of removed
+// data objects. Keys that do not exist are ignored. Removed data objects are
+// handled as Delete.
+func (x *LruMap) DeleteKeys(keys []*[]byte) int {
+	removed := 0
+	for _, key := range keys {
+		if target := x.lookup(key); target != nil {
+			x.deleteNode(target)
+			removed++
+		}
+	}
+	return removed
+}
+
+// DeleteBySecondary removes all data objects having attribute attr given by
+// WithSecondaryIndex and returns number of removed data objects. Removed
+// data objects are handled as Delete. It returns 0 if WithSecondaryIndex is
+// not given.
+func (x *LruMap) DeleteBySecondary(attr string) int {
+	nodes := x.secondaryIndex[attr]
+	targets := make([]*node, 0, len(nodes))
+	for n := range nodes {
+		targets = append(targets, n)
+	}
+
+	for _, n := range targets {
+		x.deleteNode(n)
+	}
+	return len(targets)
+}
+
+// DeleteMatching removes all data objects for which pred returns true and
+// returns number of removed data objects. Removed data objects are handled
+// as Delete.
+func (x *LruMap) DeleteMatching(pred func(LruData) bool) int {
+	var targets []*node
+	x.walk(func(n *node) bool {
+		if pred(n.data) {
+			targets = append(targets, n)
+		}
+		return true
+	})
+
+	for _, target := range targets {
+		x.deleteNode(target)
+	}
+	return len(targets)
+}
+
+// GetAndDelete returns data object of the key and removes it from the table
+// by one lookup. It is counted as Get in Stats.
+func (x *LruMap) GetAndDelete(key *[]byte) LruData {
+	x.advance()
+
+	target := x.lookup(key)
+	if target == nil {
+		x.stats.misses.Add(1)
+		return nil
+	}
+
+	x.stats.hits.Add(1)
+	return x.deleteNode(target)
+}
+
+// PopNext removes and returns data object scheduled at the nearest tick
+// from current tick. Data objects in the same frame are returned in no
+// specific order. Data object that never expires is not returned. The bool
+// value is false if there is no data object to pop. Popped data object is
+// handled as Delete.
+func (x *LruMap) PopNext() (LruData, bool) {
+	if target := x.nextExpiring(nil); target != nil {
+		return x.deleteNode(target), true
+	}
+	return nil, false
+}
+
+// PeekNext returns up to n data objects in order of expiration from current
+// tick without removing them. Order of data objects expiring at the same
+// tick is not specified, and data object that never expires is not
+// returned. PeekNext does not change the table nor the LRU order.
+func (x *LruMap) PeekNext(n int) []LruData {
+	var res []LruData
+	for i := tick(0); i < x.frameCount() && len(res) < n; i++ {
+		for lane := tick(0); lane < x.lanes; lane++ {
+			for p := x.getFrame(x.current+i, lane).link; p != nil && len(res) < n; p = p.frameLink {
+				res = append(res, p.data)
+			}
+		}
+	}
+	return res
+}
+
+// Prune is update current tick by adding `progress`.
+// If there is data object(s), they will be pruned and returned as slice.
+// Each frame is pruned at most once even if `progress` exceeds maxTick+1.
+// Prune(0) returns pointer to shared empty slice without changing anything
+// nor allocating, and the slice must not be modified.
+func (x *LruMap) Prune(progress tick) *[]LruData {
+	if progress == 0 {
+		return &noPruned
+	}
+
+	res := x.prune(progress)
+	x.notifyPruned(res)
+	return &res
+}
+
+// noPruned is returned by Prune(0) as empty result. It must not be
+// modified.
+var noPruned = []LruData{}
+
+// prune works as Prune without calling callbacks for pruned data objects.
+func (x *LruMap) prune(progress tick) []LruData {
+	var res []LruData
+	x.sweep(progress, func(n *node) {
+		res = append(res, n.data)
+	})
+	return res
+}
+
+// notifyPruned passes data objects pruned by prune to the callbacks.
+func (x *LruMap) notifyPruned(pruned []LruData) {
+	for _, d := range pruned {
+		x.notifyEvict(d, ExpiryReason)
+	}
+}
+
+// PruneLimited works as Prune, but stops after pruning maxEvict data
+// objects. The bool value is true if data objects to be pruned by progress
+// remain. In that case, current tick is advanced only to the tick of the
+// frame having the remaining data objects, then the rest can be pruned by
+// calling PruneLimited again with progress reduced by advance of
+// CurrentTick.
+func (x *LruMap) PruneLimited(progress tick, maxEvict int) (*[]LruData, bool) {
+	frames := progress
+	if frames > x.frameCount() {
+		frames = x.frameCount()
+	}
+
+	var res []LruData
+	more := false
+	var i tick
+	for ; i < frames && !more; i++ {
+		for lane := tick(0); lane < x.lanes && !more; lane++ {
+			f := x.getFrame(x.current, lane)
+			for f.link != nil {
+				if len(res) >= maxEvict {
+					more = true
+					break
+				}
+
+				n := f.link
+				res = append(res, n.data)
+				x.removeNode(n)
+				freeNode(n)
+			}
+		}
+		if !more {
+			x.current++
+			x.phase = 0
+		}
+	}
+	if !more {
+		x.current += progress - frames
+	}
+
+	x.stats.prunes.Add(uint64(len(res)))
+	x.notifyPruned(res)
+	return &res, more
+}
+
+// PruneFilter works as Prune, but data object for which keep returns true
+// is not pruned and rescheduled to expire after its original TTL from the
+// advanced current tick. Returned slice has only pruned data objects.
+func (x *LruMap) PruneFilter(progress tick, keep func(LruData) bool) *[]LruData {
+	frames := progress
+	if frames > x.frameCount() {
+		frames = x.frameCount()
+	}
+
+	var kept []*node
+	for i := tick(0); i < frames; i++ {
+		for lane := tick(0); lane < x.lanes; lane++ {
+			f := x.getFrame(x.current+i, lane)
+			for p := f.link; p != nil; {
+				next := p.frameLink
+				if keep(p.data) {
+					f.remove(p)
+					kept = append(kept, p)
+				}
+				p = next
+			}
+		}
+	}
+
+	res := x.Prune(progress)
+	for _, n := range kept {
+		n.latest = x.current
+		n.lane = x.phase
+		x.schedule(n)
+	}
+	return res
+}
+
+// PruneStep prunes data objects in the next lane of current tick and
+// returns them. Current tick is advanced by 1 after pruning the last lane,
+// then calling PruneStep as many times as lanes given by NewWithResolution
+// works as Prune(1). For LruMap created by New, PruneStep is same as
+// Prune(1).
+func (x *LruMap) PruneStep() *[]LruData {
+	var res []LruData
+	x.sweepFrame(x.current, x.phase, func(n *node) {
+		res = append(res, n.data)
+	})
+
+	x.phase++
+	if x.phase == x.lanes {
+		x.current++
+		x.phase = 0
+	}
+
+	x.notifyPruned(res)
+	return &res
+}
+
+// PruneCount works as Prune, but returns only number of pruned data objects
+// without building slice of them. The callback registered by WithOnEvict is
+// called for each data object during pruning.
+func (x *LruMap) PruneCount(progress tick) int {
+	return x.sweep(progress, func(n *node) {
+		x.notifyEvict(n.data, ExpiryReason)
+	})
+}
+
+// CountExpiringAt returns number of data objects scheduled to be pruned at
+// absolute tick t. It returns 0 if t is before current tick or beyond
+// current tick + maxTick.
+func (x *LruMap) CountExpiringAt(t tick) int {
+	if t < x.current || t > x.current+x.maxTick {
+		return 0
+	}
+	return x.countAt(t)
+}
+
+// CountExpiringWithin returns number of data objects that would be pruned
+// by Prune with progress n.
+func (x *LruMap) CountExpiringWithin(n tick) int {
+	if n > x.frameCount() {
+		n = x.frameCount()
+	}
+
+	count := 0
+	for i := tick(0); i < n; i++ {
+		count += x.countAt(x.current + i)
+	}
+	return count
+}
+
+// EvictChannel returns a channel with buffer size buf to which data objects
+// pruned by expiration are sent. If the buffer is full, the data object is
+// dropped from the channel without blocking pruning. The channel previously
+// returned by EvictChannel is closed. EvictChannel and CloseEvictChannel must
+// not be called concurrently with pruning.
+func (x *LruMap) EvictChannel(buf int) <-chan LruData {
+	x.CloseEvictChannel()
+	x.evictCh = make(chan LruData, buf)
+	return x.evictCh
+}
+
+// CloseEvictChannel closes the channel returned by EvictChannel and stops
+// sending pruned data objects.
+func (x *LruMap) CloseEvictChannel() {
+	if x.evictCh != nil {
+		close(x.evictCh)
+		x.evictCh = nil
+	}
+}
+
+// Horizon returns maximum TTL that can be scheduled from current tick.
+func (x *LruMap) Horizon() tick {
+	return x.maxTick
+}
+
+// FrameOccupancy returns number of data objects in each frame. Index i of
+// the result is the frame pruned at tick current+i, and the length is
+// maxTick+1. Data objects that never expire are not counted.
+func (x *LruMap) FrameOccupancy() []int {
+	occupancy := make([]int, x.frameCount())
+	for i := range occupancy {
+		occupancy[i] = x.countAt(x.current + tick(i))
+	}
+	return occupancy
+}
+
+// FrameInfo is a view of a frame in the wheel given by WheelSnapshot.
+type FrameInfo struct {
+	// Tick is absolute tick at which the frame is pruned.
+	Tick tick
+	// Keys is copies of keys scheduled in the frame.
+	Keys [][]byte
+}
+
+// WheelSnapshot returns all frames of the wheel ordered from current tick
+// forward with keys scheduled in each frame. The length is maxTick+1, and
+// keys of data objects that never expire are not included.
+func (x *LruMap) WheelSnapshot() []FrameInfo {
+	frames := make([]FrameInfo, x.frameCount())
+	for i := range frames {
+		t := x.current + tick(i)
+		frames[i].Tick = t
+		for lane := tick(0); lane < x.lanes; lane++ {
+			for p := x.getFrame(t, lane).link; p != nil; p = p.frameLink {
+				frames[i].Keys = append(frames[i].Keys, copyKey(p.key))
+			}
+		}
+	}
+	return frames
+}
+
+// Clear removes all data objects from LruMap table. Current tick is
+// preserved, so TTL of data objects put after Clear works as before.
+func (x *LruMap) Clear() {
+	// Release nodes not to retain data objects via nodes referred from
+	// elsewhere such as Iterator.
+	for _, bkt := range x.table {
+		for p := bkt.root.next; p != nil; {
+			next := p.next
+			freeNode(p)
+			p = next
+		}
+	}
+
+	x.table = make(map[hashValue]*bucket, x.capacity)
+	for i := range x.frames {
+		x.frames[i].link = nil
+	}
+	if x.sparse != nil {
+		x.sparse = map[tick]*frame{}
+	}
+	x.persistent.link = nil
+	x.recent = recentList{}
+	x.weight = 0
+	if x.secondary != nil {
+		x.secondaryIndex = map[string]map[*node]struct{}{}
+	}
+	x.count.Store(0)
+}
+
+// Drain removes all data objects from LruMap table as Clear and returns
+// them. Order of data objects is not specified, and the callback registered
+// by WithOnEvict is not called.
+func (x *LruMap) Drain() []LruData {
+	res := x.Entries()
+	x.Clear()
+	return res
+}
+
+// Merge inserts all data objects of other into LruMap table. Remaining TTL
+// of each data object in other is kept relative to current tick of LruMap,
+// and it is clamped to maxTick of LruMap if it is over. Data objects that
+// never expire in other also never expire in LruMap. When the key already
+// exists, onConflict is called with the existing and incoming data objects,
+// and the returned data object replaces the existing one with keeping its
+// TTL. If onConflict is nil, the existing data object is kept. other is not
+// modified. A data object that can not be inserted, e.g. by ErrChainTooLong,
+// or a result of onConflict heavier than the limit of WithMaxWeight is
+// skipped, and Merge returns the first such error after merging the rest.
+// A result of onConflict that is nil or whose Key() is different from the
+// existing data object is also skipped with an error.
+func (x *LruMap) Merge(other *LruMap, onConflict func(existing, incoming LruData) LruData) error {
+	var err error
+	other.walk(func(n *node) bool {
+		if existing := x.lookup(&n.key); existing != nil {
+			if onConflict != nil {
+				prev := copyKey(*existing.data.Key())
+				obj := onConflict(existing.data, n.data)
+				var e error
+				switch {
+				case obj == nil:
+					e = fmt.Errorf("onConflict of Merge returns nil for key %q", prev)
+				case !x.sameKey(&prev, obj.Key()):
+					e = fmt.Errorf("Key %q is changed to %q by onConflict of Merge", prev, *obj.Key())
+				case x.tooHeavy(obj):
+					e = ErrTooHeavy
+				}
+				if e != nil {
+					if err == nil {
+						err = e
+					}
+					return true
+				}
+				x.replaceData(existing, obj)
+				x.evictOverCapacity(existing)
+			}
+			return true
+		}
+
+		if n.ttl == 0 {
+			if e := x.insert(&n.key, n.data, x.current, 0); e != nil && err == nil {
+				err = e
+			}
+			return true
+		}
+
+		var remain tick
+		if n.expireAt() > other.current {
+			remain = n.expireAt() - other.current
+		}
+		if remain > x.maxTick {
+			remain = x.maxTick
+		}
+		if e := x.insertRemaining(&n.key, n.data, remain); e != nil && err == nil {
+			err = e
+		}
+		return true
+	})
+	return err
+}
+
+// Resize replaces maxTick of LruMap with newMaxTick and reschedules all
+// data objects into frames of the new size. Expiration tick of each data
+// object is kept. Original TTL of data object used by sliding expiration is
+// clamped to newMaxTick. Resize returns ErrTTLTooLarge without any change if
+// remaining TTL of a data object is over newMaxTick, and ErrInvalidMaxTick
+// if newMaxTick is 0.
+func (x *LruMap) Resize(newMaxTick tick) error {
+	if newMaxTick == 0 {
+		return ErrInvalidMaxTick
+	}
+
+	var err error
+	var scheduled []*node
+	x.eachFrame(func(f *frame) {
+		for p := f.link; p != nil && err == nil; p = p.frameLink {
+			if remain := p.expireAt() - x.current; remain > newMaxTick {
+				err = fmt.Errorf("%w: remaining TTL %d of key %q is over new maxTick %d", ErrTTLTooLarge, remain, p.key, newMaxTick)
+			}
+			scheduled = append(scheduled, p)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	x.maxTick = newMaxTick
+	if x.sparse != nil {
+		x.sparse = map[tick]*frame{}
+	} else {
+		x.frames = make([]frame, (newMaxTick+1)*x.lanes)
+	}
+	for _, p := range scheduled {
+		if p.ttl > newMaxTick {
+			p.latest, p.ttl = p.expireAt()-newMaxTick, newMaxTick
+		}
+		if p.idle > newMaxTick {
+			p.idle = newMaxTick
+		}
+		x.schedule(p)
+	}
+	return nil
+}
+
+// Clone returns an independent copy of LruMap. Data objects are shared
+// with the original, but modification of the clone such as Put, Delete and
+// Prune does not affect the original.
+func (x *LruMap) Clone() *LruMap {
+	c := &LruMap{
+		table:          make(map[hashValue]*bucket, len(x.table)),
+		current:        x.current,
+		maxTick:        x.maxTick,
+		lanes:          x.lanes,
+		phase:          x.phase,
+		sliding:        x.sliding,
+		name:           x.name,
+		onEvict:        x.onEvict,
+		onEvictReason:  x.onEvictReason,
+		evictOnDelete:  x.evictOnDelete,
+		maxEntries:     x.maxEntries,
+		maxWeight:      x.maxWeight,
+		weight:         x.weight,
+		hash:           x.hash,
+		capacity:       x.capacity,
+		maxChainLength: x.maxChainLength,
+		keyEqual:       x.keyEqual,
+		clampTTL:       x.clampTTL,
+		secondary:      x.secondary,
+		clock:          x.clock,
+		origin:         x.origin,
+		resolution:     x.resolution,
+		jitter:         x.jitter,
+		jitterSource:   x.jitterSource,
+	}
+
+	c.count.Store(x.count.Load())
+	c.idleUsed.Store(x.idleUsed.Load())
+
+	cloned := make(map[*node]*node, x.Size())
+	for hv, bkt := range x.table {
+		newBkt := &bucket{equal: x.keyEqual}
+		tail := &newBkt.root
+		for p := bkt.root.next; p != nil; p = p.next {
+			n := &node{
+				key:    p.key,
+				data:   p.data,
+				hv:     p.hv,
+				latest: p.latest,
+				lane:   p.lane,
+				ttl:    p.ttl,
+				weight: p.weight,
+
+				idle:     p.idle,
+				deadline: p.deadline,
+			}
+			tail.attach(n)
+			tail = n
+			cloned[p] = n
+		}
+		c.table[hv] = newBkt
+	}
+
+	if x.sparse != nil {
+		c.sparse = make(map[tick]*frame, len(x.sparse))
+		for slot, f := range x.sparse {
+			c.sparse[slot] = &frame{}
+			cloneFrame(c.sparse[slot], f, cloned)
+		}
+	} else {
+		c.frames = make([]frame, len(x.frames))
+		for i := range x.frames {
+			cloneFrame(&c.frames[i], &x.frames[i], cloned)
+		}
+	}
+	cloneFrame(&c.persistent, &x.persistent, cloned)
+
+	if x.recent.root.lruPrev != nil {
+		for p := x.recent.root.lruPrev; p != &x.recent.root; p = p.lruPrev {
+			c.recent.moveToFront(cloned[p])
+		}
+	}
+
+	c.stats.hits.Store(x.stats.hits.Load())
+	c.stats.misses.Store(x.stats.misses.Load())
+	c.stats.puts.Store(x.stats.puts.Load())
+	c.stats.prunes.Store(x.stats.prunes.Load())
+	c.stats.evictions.Store(x.stats.evictions.Load())
+
+	if x.secondary != nil {
+		c.secondaryIndex = map[string]map[*node]struct{}{}
+		for orig, n := range cloned {
+			n.attr = orig.attr
+			c.addIndex(n)
+		}
+	}
+
+	return c
 }
 
-// New is a constructor of LruMap
-func New(maxTick tick) *LruMap {
-	lruMap := LruMap{
-		table:   map[hashValue]*bucket{},
-		frames:  make([]frame, maxTick+1),
-		maxTick: maxTick,
+// cloneFrame links cloned nodes of src to dst keeping the order.
+func cloneFrame(dst, src *frame, cloned map[*node]*node) {
+	var links []*node
+	for p := src.link; p != nil; p = p.frameLink {
+		links = append(links, cloned[p])
+	}
+	for i := len(links) - 1; i >= 0; i-- {
+		dst.add(links[i])
 	}
-	return &lruMap
 }
 
-// Put inserts data object into LruMap table.
-// LruMap does not allow to insert object with duplicated key.
-func (x *LruMap) Put(obj LruData, ttl tick) error {
-	if ttl > x.maxTick {
-		return errors.New("TTL is over maxTick")
+// Keys returns copies of all keys in LruMap table. Order of keys is
+// not specified.
+func (x *LruMap) Keys() [][]byte {
+	keys := make([][]byte, 0, x.Size())
+	x.walk(func(n *node) bool {
+		keys = append(keys, append([]byte{}, n.key...))
+		return true
+	})
+	return keys
+}
+
+// Entries returns all data objects in LruMap table. Order of data objects
+// is not specified.
+func (x *LruMap) Entries() []LruData {
+	entries := make([]LruData, 0, x.Size())
+	x.walk(func(n *node) bool {
+		entries = append(entries, n.data)
+		return true
+	})
+	return entries
+}
+
+// ForEach calls fn for each data object in LruMap table. Iteration stops
+// when fn returns false. Order of data objects is not specified.
+func (x *LruMap) ForEach(fn func(LruData) bool) {
+	x.walk(func(n *node) bool {
+		return fn(n.data)
+	})
+}
+
+// RangeByExpiry calls fn for each data object with its absolute expiration
+// tick in ascending order of the tick. Order of data objects expiring at the
+// same tick is not specified. Data objects that never expire come last with
+// tick 0. Iteration stops when fn returns false, and fn must not modify the
+// LruMap. The tick is passed as uint64 so that fn can be declared outside
+// of the package.
+func (x *LruMap) RangeByExpiry(fn func(LruData, uint64) bool) {
+	for i := tick(0); i < x.frameCount(); i++ {
+		for lane := tick(0); lane < x.lanes; lane++ {
+			for p := x.getFrame(x.current+i, lane).link; p != nil; p = p.frameLink {
+				if !fn(p.data, uint64(p.expireAt())) {
+					return
+				}
+			}
+		}
 	}
 
-	hv := fnvHash(obj.Key())
-	bkt := x.table[hv]
-	if bkt == nil {
-		bkt = &bucket{}
-		x.table[hv] = bkt
+	for p := x.persistent.link; p != nil; p = p.frameLink {
+		if !fn(p.data, 0) {
+			return
+		}
+	}
+}
+
+// CountBy returns number of data objects in LruMap table for each group
+// returned by group.
+func (x *LruMap) CountBy(group func(LruData) string) map[string]int {
+	counts := map[string]int{}
+	x.walk(func(n *node) bool {
+		counts[group(n.data)]++
+		return true
+	})
+	return counts
+}
+
+// Iterator walks data objects in LruMap table one by one. LruMap must not
+// be modified until iteration finishes because Iterator refers nodes in the
+// table directly.
+type Iterator struct {
+	buckets []*bucket
+	node    *node
+}
+
+// Iterator returns Iterator of all data objects in LruMap table. Order of
+// data objects is not specified.
+func (x *LruMap) Iterator() *Iterator {
+	buckets := make([]*bucket, 0, len(x.table))
+	for _, bkt := range x.table {
+		buckets = append(buckets, bkt)
+	}
+	return &Iterator{buckets: buckets}
+}
+
+// Next returns the next data object. The bool value is false if all data
+// objects are already returned.
+func (x *Iterator) Next() (LruData, bool) {
+	if x.node != nil {
+		x.node = x.node.next
+	}
+	for x.node == nil {
+		if len(x.buckets) == 0 {
+			return nil, false
+		}
+		x.node = x.buckets[0].root.next
+		x.buckets = x.buckets[1:]
+	}
+	return x.node.data, true
+}
+
+// Size returns number of data object in the LruMap table. Size can be
+// called without lock via SyncLruMap because the counter is atomic.
+func (x *LruMap) Size() int {
+	return int(x.count.Load())
+}
+
+// Empty returns true if the LruMap table has no data object.
+func (x *LruMap) Empty() bool {
+	return x.Size() == 0
+}
+
+// TotalWeight returns total weight of data objects in the LruMap table.
+// See WithMaxWeight for weight of data object.
+func (x *LruMap) TotalWeight() int {
+	return x.weight
+}
+
+// Name returns name of LruMap given by WithName.
+func (x *LruMap) Name() string {
+	return x.name
+}
+
+// CurrentTick returns current tick of LruMap that is advanced by Prune.
+func (x *LruMap) CurrentTick() tick {
+	return x.current
+}
+
+// Wrapped returns true if current tick is over maxTick, then frames of the
+// wheel have been reused at least once.
+func (x *LruMap) Wrapped() bool {
+	return x.current > x.maxTick
+}
+
+// Revolutions returns number of full turns of the wheel, that is current
+// tick divided by number of frames (maxTick+1).
+func (x *LruMap) Revolutions() uint64 {
+	return uint64(x.current / x.frameCount())
+}
+
+// MaxTick returns maximum TTL of LruMap given to New.
+func (x *LruMap) MaxTick() tick {
+	return x.maxTick
+}
+
+// getFrame returns the frame of lane pruned at tick t. With
+// WithSparseFrames, it returns a shared empty frame if no node is scheduled
+// in the frame, then nodes must be added via schedule.
+func (x *LruMap) getFrame(t, lane tick) *frame {
+	p := x.slot(t, lane)
+	if x.sparse == nil {
+		return &x.frames[p]
+	}
+	if f := x.sparse[p]; f != nil {
+		return f
+	}
+	return &emptyFrame
+}
+
+// emptyFrame is returned by getFrame for a slot without nodes of sparse
+// frames. It must not be modified.
+var emptyFrame frame
+
+// frameCount returns number of ticks in the wheel. Each tick has frames as
+// many as lanes.
+func (x *LruMap) frameCount() tick {
+	return x.maxTick + 1
+}
+
+// slot returns index of the frame of lane pruned at tick t.
+func (x *LruMap) slot(t, lane tick) tick {
+	return t%x.frameCount()*x.lanes + lane
+}
+
+// laneOf returns lane of the node within its expiration tick, that is the
+// lane of current tick when the node was scheduled.
+func (x *LruMap) laneOf(target *node) tick {
+	return target.lane
+}
+
+// countAt returns number of nodes scheduled at tick t in all lanes.
+func (x *LruMap) countAt(t tick) int {
+	count := 0
+	for lane := tick(0); lane < x.lanes; lane++ {
+		count += x.getFrame(t, lane).count()
+	}
+	return count
+}
+
+// eachFrame calls fn for each frame of the wheel. Order of frames is not
+// specified, and empty frames may be skipped.
+func (x *LruMap) eachFrame(fn func(f *frame)) {
+	if x.sparse != nil {
+		for _, f := range x.sparse {
+			fn(f)
+		}
+		return
+	}
+	for i := range x.frames {
+		fn(&x.frames[i])
+	}
+}
+
+// schedule adds the node to the frame of its expiration tick.
+func (x *LruMap) schedule(target *node) {
+	if x.sparse == nil || target.ttl == 0 {
+		x.frameOf(target).add(target)
+		return
+	}
+
+	p := x.slot(target.expireAt(), x.laneOf(target))
+	f := x.sparse[p]
+	if f == nil {
+		f = &frame{}
+		x.sparse[p] = f
+	}
+	f.add(target)
+}
+
+// unschedule removes the node from its frame, and releases the frame if it
+// becomes empty with WithSparseFrames.
+func (x *LruMap) unschedule(target *node) {
+	f := x.frameOf(target)
+	f.remove(target)
+	if x.sparse != nil && target.ttl > 0 {
+		x.releaseFrame(target.expireAt(), x.laneOf(target))
+	}
+}
+
+// releaseFrame deletes empty frame of lane at tick t with WithSparseFrames.
+func (x *LruMap) releaseFrame(t, lane tick) {
+	if x.sparse == nil {
+		return
+	}
+	p := x.slot(t, lane)
+	if f := x.sparse[p]; f != nil && f.link == nil {
+		delete(x.sparse, p)
+	}
+}
+
+// frameOf returns the frame in which the node is scheduled. Node with ttl 0
+// belongs to the persistent frame that is never pruned.
+func (x *LruMap) frameOf(target *node) *frame {
+	if target.ttl == 0 {
+		return &x.persistent
+	}
+	return x.getFrame(target.expireAt(), x.laneOf(target))
+}
+
+// mutableGet returns true if Get may modify the table.
+func (x *LruMap) mutableGet() bool {
+	return x.sliding || x.resolution > 0 || x.maxEntries > 0 || x.idleUsed.Load()
+}
+
+// sweep prunes frames from current tick, advances current tick by progress
+// and returns number of pruned nodes. fn is called for each pruned node.
+// Each frame is pruned at most once even if progress exceeds maxTick+1.
+func (x *LruMap) sweep(progress tick, fn func(n *node)) int {
+	frames := progress
+	if frames > x.frameCount() {
+		frames = x.frameCount()
+	}
+
+	pruned := 0
+	for i := tick(0); i < frames; i++ {
+		for lane := tick(0); lane < x.lanes; lane++ {
+			pruned += x.sweepFrame(x.current+i, lane, fn)
+		}
+	}
+
+	x.current += progress
+	x.phase = 0
+	return pruned
+}
+
+// sweepFrame prunes the frame of lane at tick t and returns number of
+// pruned nodes. fn is called for each pruned node.
+func (x *LruMap) sweepFrame(t, lane tick, fn func(n *node)) int {
+	pruned := 0
+	if f := x.getFrame(t, lane); f.link != nil {
+		f.prune(func(n *node) {
+			x.releaseBucket(n.hv)
+			x.weight -= n.weight
+			x.unindexNode(n)
+			pruned++
+			fn(n)
+			freeNode(n)
+		})
+	}
+	x.releaseFrame(t, lane)
+
+	x.count.Add(-int64(pruned))
+	x.stats.prunes.Add(uint64(pruned))
+	return pruned
+}
+
+// removeNode unlinks the node from bucket, frame and recency list.
+func (x *LruMap) removeNode(target *node) {
+	target.detach()
+	x.releaseBucket(target.hv)
+	x.unschedule(target)
+	x.weight -= target.weight
+	x.unindexNode(target)
+	x.count.Add(-1)
+}
+
+// evictNode removes the node and passes its data object to the callbacks
+// registered by WithOnEvict and WithOnEvictReason.
+func (x *LruMap) evictNode(target *node, reason EvictReason) {
+	data := target.data
+	x.removeNode(target)
+	freeNode(target)
+	x.notifyEvict(data, reason)
+}
+
+// notifyEvict passes removed data object to the callbacks. The callback of
+// WithOnEvict is called for DeleteReason only if WithEvictOnDelete is given.
+func (x *LruMap) notifyEvict(data LruData, reason EvictReason) {
+	if x.deferEvict {
+		x.pendingEvicts = append(x.pendingEvicts, pendingEvict{data: data, reason: reason})
+		return
+	}
+	x.dispatchEvict(data, reason)
+}
+
+// pendingEvict is a removed data object kept by notifyEvict with
+// deferEvict.
+type pendingEvict struct {
+	data   LruData
+	reason EvictReason
+}
+
+// takePendingEvicts returns data objects kept by notifyEvict and clears
+// them.
+func (x *LruMap) takePendingEvicts() []pendingEvict {
+	pending := x.pendingEvicts
+	x.pendingEvicts = nil
+	return pending
+}
+
+// dispatchEvict calls the callbacks for the removed data object.
+func (x *LruMap) dispatchEvict(data LruData, reason EvictReason) {
+	if x.onEvict != nil && (reason != DeleteReason || x.evictOnDelete) {
+		x.onEvict(data)
+	}
+	if x.onEvictReason != nil {
+		x.onEvictReason(data, reason)
+	}
+	if x.evictCh != nil && reason == ExpiryReason {
+		select {
+		case x.evictCh <- data:
+		default:
+		}
+	}
+}
+
+// deleteNode removes the node as Delete and returns its data object.
+func (x *LruMap) deleteNode(target *node) LruData {
+	data := target.data
+	x.removeNode(target)
+	freeNode(target)
+
+	x.notifyEvict(data, DeleteReason)
+	return data
+}
+
+// releaseBucket deletes bucket of the hash value from the table if the
+// bucket has no node.
+func (x *LruMap) releaseBucket(hv hashValue) {
+	if bkt := x.table[hv]; bkt != nil && bkt.root.next == nil {
+		delete(x.table, hv)
+		bucketPool.Put(bkt)
+	}
+}
+
+// touch marks the node as most recently used if capacity limit is enabled.
+func (x *LruMap) touch(target *node) {
+	if x.maxEntries > 0 {
+		x.recent.moveToFront(target)
 	}
+}
+
+// evictOverCapacity removes least recently used data objects until number
+// of data objects fits in maxEntries. Then it removes data objects nearest
+// to expiration until total weight fits in maxWeight.
+func (x *LruMap) evictOverCapacity(keep *node) {
+	for x.maxEntries > 0 && x.Size() > x.maxEntries {
+		oldest := x.recent.oldest()
+		if oldest == nil {
+			return
+		}
 
-	newNode := node{
-		data: obj,
+		x.evictNode(oldest, CapacityReason)
+		x.stats.evictions.Add(1)
 	}
-	bkt.insert(&newNode)
 
-	cur := x.getFrame(x.current + ttl)
-	cur.add(&newNode)
+	for x.maxWeight > 0 && x.weight > x.maxWeight {
+		target := x.nextExpiring(keep)
+		if target == nil {
+			target = x.persistent.link
+			if target == keep {
+				target = target.frameLink
+			}
+		}
+		if target == nil {
+			return
+		}
 
-	x.count++
+		x.evictNode(target, CapacityReason)
+		x.stats.evictions.Add(1)
+	}
+}
 
+// nextExpiring returns a node scheduled at the nearest tick from current
+// tick except the given node. In the same frame, the node scheduled most
+// recently is returned. It returns nil if there is no node that expires.
+func (x *LruMap) nextExpiring(except *node) *node {
+	for i := tick(0); i < x.frameCount(); i++ {
+		for lane := tick(0); lane < x.lanes; lane++ {
+			target := x.getFrame(x.current+i, lane).link
+			if target == except && target != nil {
+				target = target.frameLink
+			}
+			if target != nil {
+				return target
+			}
+		}
+	}
 	return nil
 }
 
-// Get returns data object if exists.
-func (x *LruMap) Get(key *[]byte) LruData {
-	hv := fnvHash(key)
+// indexNode adds the node to the secondary index with attribute given by
+// WithSecondaryIndex.
+func (x *LruMap) indexNode(target *node) {
+	if x.secondary == nil {
+		return
+	}
+	target.attr = x.secondary(target.data)
+	x.addIndex(target)
+}
+
+func (x *LruMap) addIndex(target *node) {
+	nodes := x.secondaryIndex[target.attr]
+	if nodes == nil {
+		nodes = map[*node]struct{}{}
+		x.secondaryIndex[target.attr] = nodes
+	}
+	nodes[target] = struct{}{}
+}
+
+// unindexNode removes the node from the secondary index.
+func (x *LruMap) unindexNode(target *node) {
+	if x.secondary == nil {
+		return
+	}
+
+	nodes := x.secondaryIndex[target.attr]
+	delete(nodes, target)
+	if len(nodes) == 0 {
+		delete(x.secondaryIndex, target.attr)
+	}
+}
+
+// replaceData replaces data object of the node and updates total weight.
+func (x *LruMap) replaceData(target *node, obj LruData) {
+	x.weight -= target.weight
+	x.unindexNode(target)
+	target.data = obj
+	target.weight = weightOf(obj)
+	x.weight += target.weight
+	x.indexNode(target)
+}
+
+// tooHeavy returns true if the data object alone exceeds the limit of
+// WithMaxWeight.
+func (x *LruMap) tooHeavy(obj LruData) bool {
+	return x.maxWeight > 0 && weightOf(obj) > x.maxWeight
+}
+
+// weightOf returns weight of data object for WithMaxWeight.
+func weightOf(obj LruData) int {
+	if w, ok := obj.(Weighted); ok {
+		return w.Weight()
+	}
+	return 1
+}
+
+// chainFull returns true if the bucket has nodes as many as the limit of
+// WithMaxChainLength.
+func (x *LruMap) chainFull(bkt *bucket) bool {
+	if x.maxChainLength <= 0 {
+		return false
+	}
+
+	n := 0
+	for p := bkt.root.next; p != nil; p = p.next {
+		n++
+	}
+	return n >= x.maxChainLength
+}
+
+// getBucket returns bucket of the hash value and creates it if not exists.
+func (x *LruMap) getBucket(hv hashValue) *bucket {
 	bkt := x.table[hv]
 	if bkt == nil {
-		return nil
+		bkt = bucketPool.Get().(*bucket)
+		bkt.equal = x.keyEqual
+		x.table[hv] = bkt
 	}
+	return bkt
+}
 
-	searched := bkt.search(key)
-	if searched == nil {
+// sameKey compares keys of data objects as the table does, by the function
+// given by WithKeyEqual or bytes.Equal.
+func (x *LruMap) sameKey(a, b *[]byte) bool {
+	if x.keyEqual != nil {
+		return x.keyEqual(a, b)
+	}
+	return bytes.Equal(*a, *b)
+}
+
+func (x *LruMap) lookup(key *[]byte) *node {
+	bkt := x.table[x.hash(key)]
+	if bkt == nil {
 		return nil
 	}
-	return searched.data
+	return bkt.search(key)
 }
 
-// Prune is update current tick by adding `progress`.
-// If there is data object(s), they will be pruned and returned as slice.
-func (x *LruMap) Prune(progress tick) *[]LruData {
-	var res []LruData
-	for i := tick(0); i < progress; i++ {
-		f := x.getFrame(x.current + i)
-		res = append(res, (*f.prune())...)
+// walk calls fn for each node in all buckets until fn returns false.
+func (x *LruMap) walk(fn func(n *node) bool) {
+	for _, bkt := range x.table {
+		for p := bkt.root.next; p != nil; p = p.next {
+			if !fn(p) {
+				return
+			}
+		}
 	}
+}
 
-	x.count -= len(res)
-	x.current += progress
-	return &res
+// reschedule moves the node from current frame to the frame of current+ttl.
+// Time-to-idle of the node given by PutWithIdle is replaced by ttl.
+func (x *LruMap) reschedule(target *node, ttl tick) {
+	target.idle, target.deadline = 0, 0
+	x.moveFrame(target, ttl)
 }
 
-// Size returns number of data object in the LruMap table.
-func (x *LruMap) Size() int {
-	return x.count
+func (x *LruMap) moveFrame(target *node, ttl tick) {
+	x.unschedule(target)
+	target.latest = x.current
+	target.lane = x.phase
+	target.ttl = ttl
+	x.schedule(target)
 }
 
-func (x *LruMap) getFrame(t tick) *frame {
-	p := t % tick(len(x.frames))
-	return &x.frames[p]
+// extend reschedules the accessed node to expire after its original TTL,
+// or after its idle TTL capped at its deadline for node put by PutWithIdle.
+func (x *LruMap) extend(target *node) {
+	if target.idle == 0 {
+		x.reschedule(target, target.ttl)
+		return
+	}
+	if ttl, ok := x.idleTTL(target); ok {
+		x.moveFrame(target, ttl)
+	}
+}
+
+// idleTTL returns TTL of the node from current tick by its idle TTL and
+// deadline. The bool value is false if the node reaches the deadline.
+func (x *LruMap) idleTTL(target *node) (tick, bool) {
+	if target.deadline == 0 {
+		return target.idle, true
+	}
+	if target.deadline <= x.current {
+		return 0, false
+	}
+	if remain := target.deadline - x.current; remain < target.idle {
+		return remain, true
+	}
+	return target.idle, true
 }
 
 type tick uint64
 
 type node struct {
-	next, prev *node
-	frameLink  *node
-	data       LruData
-	latest     tick
-	ttl        tick
+	next, prev       *node
+	frameLink        *node
+	framePrev        *node
+	lruNext, lruPrev *node
+	key              []byte
+	data             LruData
+	hv               hashValue
+	latest           tick
+	lane             tick
+	ttl              tick
+	weight           int
+	attr             string
+	// idle is TTL reset by access for node put by PutWithIdle, and deadline
+	// is absolute tick that the node must expire by. deadline 0 means no
+	// hard TTL.
+	idle     tick
+	deadline tick
+}
+
+var nodePool = sync.Pool{
+	New: func() interface{} {
+		return &node{}
+	},
+}
+
+// bucketPool keeps empty buckets released after Prune and Delete, so that
+// re-inserting the keys does not allocate buckets again.
+var bucketPool = sync.Pool{
+	New: func() interface{} {
+		return &bucket{}
+	},
+}
+
+// allocNode returns a cleared node from the pool.
+func allocNode() *node {
+	return nodePool.Get().(*node)
+}
+
+// copyKey returns a copy of key owned by LruMap.
+func copyKey(key []byte) []byte {
+	return append(make([]byte, 0, len(key)), key...)
+}
+
+// freeNode clears the node and returns it to the pool. The node must not be
+// referred after freeNode. Clearing also drops reference to the data object,
+// then the data object can be collected even while the node is pooled.
+func freeNode(n *node) {
+	*n = node{}
+	nodePool.Put(n)
+}
+
+// expireAt returns the tick of the frame in which the node is scheduled.
+func (x *node) expireAt() tick {
+	return x.latest + x.ttl
 }
 
 func (x *node) attach(target *node) {
@@ -126,18 +1851,23 @@ func (x *node) detach() {
 	}
 	x.next = nil
 	x.prev = nil
+	x.detachRecent()
 	return
 }
 
-func (x *node) equals(target *node) bool {
-	if x.data == nil || target.data == nil {
-		return false
+func (x *node) detachRecent() {
+	if x.lruPrev != nil {
+		x.lruPrev.lruNext = x.lruNext
 	}
-	return x.matchKey(target.data.Key())
+	if x.lruNext != nil {
+		x.lruNext.lruPrev = x.lruPrev
+	}
+	x.lruNext = nil
+	x.lruPrev = nil
 }
 
 func (x *node) matchKey(key *[]byte) bool {
-	return bytes.Equal(*(x.data.Key()), *key)
+	return bytes.Equal(x.key, *key)
 }
 
 type frame struct {
@@ -148,37 +1878,116 @@ func (x *frame) add(target *node) {
 	next := x.link
 	x.link = target
 	target.frameLink = next
+	target.framePrev = nil
+	if next != nil {
+		next.framePrev = target
+	}
 }
 
-func (x *frame) prune() *[]LruData {
-	var prunedData []LruData
-	for link := x.link; link != nil; link = link.frameLink {
-		link.detach()
-		prunedData = append(prunedData, link.data)
+// count returns number of nodes linked to the frame.
+func (x *frame) count() int {
+	n := 0
+	for p := x.link; p != nil; p = p.frameLink {
+		n++
 	}
+	return n
+}
+
+// remove unlinks the node from the frame. The node must be linked to the
+// frame.
+func (x *frame) remove(target *node) {
+	if target.framePrev != nil {
+		target.framePrev.frameLink = target.frameLink
+	} else if x.link == target {
+		x.link = target.frameLink
+	}
+	if target.frameLink != nil {
+		target.frameLink.framePrev = target.framePrev
+	}
+	target.frameLink = nil
+	target.framePrev = nil
+}
+
+// prune unlinks all nodes of the frame from their bucket and calls fn for
+// each node.
+func (x *frame) prune(fn func(n *node)) {
+	link := x.link
 	x.link = nil
-	return &prunedData
+	for link != nil {
+		next := link.frameLink
+		link.detach()
+		fn(link)
+		link = next
+	}
+}
+
+// recentList is a circular doubly linked list of nodes ordered by recency.
+// root.lruNext is the most recently used node and root.lruPrev is the least
+// recently used one.
+type recentList struct {
+	root node
+}
+
+func (x *recentList) moveToFront(target *node) {
+	if x.root.lruNext == nil {
+		x.root.lruNext = &x.root
+		x.root.lruPrev = &x.root
+	}
+
+	target.detachRecent()
+	target.lruNext = x.root.lruNext
+	target.lruPrev = &x.root
+	x.root.lruNext.lruPrev = target
+	x.root.lruNext = target
+}
+
+func (x *recentList) oldest() *node {
+	if x.root.lruPrev == nil || x.root.lruPrev == &x.root {
+		return nil
+	}
+	return x.root.lruPrev
 }
 
 type bucket struct {
 	root node
+	// equal compares keys instead of bytes.Equal if not nil
+	equal func(a, b *[]byte) bool
+}
+
+// match returns true if key of the node is equal to key.
+func (x *bucket) match(n *node, key *[]byte) bool {
+	if x.equal != nil {
+		return x.equal(&n.key, key)
+	}
+	return n.matchKey(key)
 }
 
 func (x *bucket) insert(newNode *node) error {
-	var p *node
-	for p = &x.root; p.next != nil; p = p.next {
-		if p.equals(newNode) {
-			return errors.New("Duplicated key")
+	if x.searchOrInsert(newNode) != nil {
+		return ErrDuplicateKey
+	}
+	return nil
+}
+
+// searchOrInsert returns existing node having the same key as newNode.
+// If not found, newNode is attached to tail of the bucket and nil is
+// returned.
+func (x *bucket) searchOrInsert(newNode *node) *node {
+	tail := &x.root
+	for p := x.root.next; p != nil; p = p.next {
+		if x.match(p, &newNode.key) {
+			return p
 		}
+		tail = p
 	}
 
-	p.attach(newNode)
+	tail.attach(newNode)
 	return nil
 }
 
 func (x *bucket) search(key *[]byte) *node {
 	for p := x.root.next; p != nil; p = p.next {
-		if p.matchKey(key) {
+		if x.match(p, key) {
 			return p
 		}
 	}
@@ -188,6 +1997,18 @@ func (x *bucket) search(key *[]byte) *node {
 
 type hashValue uint64
 
+// HashKey returns FNV-1a hash value of key that is used by LruMap by default
+// and by ShardedLruMap to choose a shard.
+func HashKey(key *[]byte) uint64 {
+	return uint64(fnvHash(key))
+}
+
+// HashKey returns hash value of key used in the LruMap table. It is same as
+// the package level HashKey unless WithHasher is given.
+func (x *LruMap) HashKey(key *[]byte) uint64 {
+	return uint64(x.hash(key))
+}
+
 // FNV hash based on gopacket.
 // See http://isthe.com/chongo/tech/comp/fnv/.
 func fnvHash(s *[]byte) (h hashValue) {
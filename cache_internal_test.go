@@ -0,0 +1,351 @@
+package lrumap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type internalData struct {
+	key []byte
+}
+
+func (x *internalData) Key() *[]byte {
+	return &x.key
+}
+
+func newInternalNode(key string) *node {
+	return &node{
+		key:  []byte(key),
+		data: &internalData{key: []byte(key)},
+	}
+}
+
+func TestBucketInsertDuplicatedTail(t *testing.T) {
+	// Nodes in one bucket are regarded as colliding keys.
+	bkt := bucket{}
+	assert.Nil(t, bkt.insert(newInternalNode("a")))
+	assert.Nil(t, bkt.insert(newInternalNode("b")))
+	assert.Nil(t, bkt.insert(newInternalNode("c")))
+
+	// Duplicate of the last node must be rejected, too.
+	assert.NotNil(t, bkt.insert(newInternalNode("c")))
+	assert.NotNil(t, bkt.insert(newInternalNode("a")))
+
+	n := 0
+	for p := bkt.root.next; p != nil; p = p.next {
+		n++
+	}
+	assert.Equal(t, 3, n)
+}
+
+func TestPruneOverFramesAdvancesCurrent(t *testing.T) {
+	lru := New(12)
+	assert.Nil(t, lru.Put(&internalData{key: []byte("a")}, 3))
+	assert.Equal(t, 1, len(*lru.Prune(30)))
+	assert.Equal(t, tick(30), lru.current)
+}
+
+func TestReleaseEmptyBucket(t *testing.T) {
+	lru := New(12)
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		assert.Nil(t, lru.Put(&internalData{key: key}, tick(i%5+1)))
+	}
+	assert.Equal(t, 100, len(lru.table))
+
+	// Delete
+	key := []byte("key0")
+	assert.NotNil(t, lru.Delete(&key))
+	assert.Equal(t, 99, len(lru.table))
+
+	// Prune
+	lru.Prune(3)
+	assert.Equal(t, 60, len(lru.table))
+	lru.Prune(3)
+	assert.Equal(t, 0, len(lru.table))
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestReleaseSharedBucket(t *testing.T) {
+	lru := New(12, WithHasher(func(key *[]byte) uint64 { return 0 }))
+	assert.Nil(t, lru.Put(&internalData{key: []byte("a")}, 1))
+	assert.Nil(t, lru.Put(&internalData{key: []byte("b")}, 3))
+
+	// Bucket remains while it has a node
+	lru.Prune(2)
+	assert.Equal(t, 1, len(lru.table))
+	lru.Prune(2)
+	assert.Equal(t, 0, len(lru.table))
+}
+
+func frameKeys(f *frame) []string {
+	var keys []string
+	var last *node
+	for p := f.link; p != nil; p = p.frameLink {
+		keys = append(keys, string(p.key))
+		last = p
+	}
+
+	// Backward links must be consistent with forward links
+	var reversed []string
+	for p := last; p != nil; p = p.framePrev {
+		reversed = append([]string{string(p.key)}, reversed...)
+	}
+	if len(reversed) != len(keys) {
+		return nil
+	}
+	for i := range keys {
+		if keys[i] != reversed[i] {
+			return nil
+		}
+	}
+	return keys
+}
+
+func TestFrameRemove(t *testing.T) {
+	f := frame{}
+	nodes := map[string]*node{}
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		nodes[key] = newInternalNode(key)
+		f.add(nodes[key])
+	}
+	assert.Equal(t, []string{"e", "d", "c", "b", "a"}, frameKeys(&f))
+
+	// Middle
+	f.remove(nodes["c"])
+	assert.Equal(t, []string{"e", "d", "b", "a"}, frameKeys(&f))
+	// Head
+	f.remove(nodes["e"])
+	assert.Equal(t, []string{"d", "b", "a"}, frameKeys(&f))
+	// Tail
+	f.remove(nodes["a"])
+	assert.Equal(t, []string{"d", "b"}, frameKeys(&f))
+
+	// Re-add removed node
+	f.add(nodes["c"])
+	assert.Equal(t, []string{"c", "d", "b"}, frameKeys(&f))
+
+	var pruned []string
+	f.prune(func(n *node) {
+		pruned = append(pruned, string(n.key))
+	})
+	assert.Equal(t, []string{"c", "d", "b"}, pruned)
+	assert.Nil(t, f.link)
+}
+
+func TestVerify(t *testing.T) {
+	newMap := func() *LruMap {
+		lru := New(12)
+		assert.Nil(t, lru.Put(&internalData{key: []byte("a")}, 1))
+		assert.Nil(t, lru.Put(&internalData{key: []byte("b")}, 5))
+		assert.Nil(t, lru.Put(&internalData{key: []byte("c")}, 5))
+		assert.Nil(t, lru.Put(&internalData{key: []byte("d")}, 0))
+		return lru
+	}
+	key := []byte("b")
+
+	healthy := newMap()
+	assert.NoError(t, healthy.Verify())
+	healthy.Delete(&key)
+	healthy.Prune(3)
+	assert.NoError(t, healthy.Verify())
+
+	// Count mismatch
+	broken := newMap()
+	broken.count.Add(1)
+	assert.Error(t, broken.Verify())
+
+	// Node unlinked from frame
+	broken = newMap()
+	target := broken.lookup(&key)
+	broken.frameOf(target).remove(target)
+	assert.Error(t, broken.Verify())
+
+	// Node linked to wrong frame
+	broken = newMap()
+	target = broken.lookup(&key)
+	broken.frameOf(target).remove(target)
+	broken.getFrame(3, 0).add(target)
+	assert.Error(t, broken.Verify())
+
+	// Node unlinked from bucket
+	broken = newMap()
+	broken.lookup(&key).detach()
+	assert.Error(t, broken.Verify())
+
+	// Node linked to two bucket chains
+	broken = newMap()
+	target = broken.lookup(&key)
+	other := &bucket{}
+	other.root.next = target
+	broken.table[target.hv+1] = other
+	err := broken.Verify()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "twice")
+}
+
+func TestSparseFrames(t *testing.T) {
+	lru := New(10, WithSparseFrames())
+	assert.Nil(t, lru.frames)
+
+	keyA, keyB, keyC := []byte("a"), []byte("b"), []byte("c")
+	assert.Nil(t, lru.Put(&internalData{key: keyA}, 3))
+	assert.Nil(t, lru.Put(&internalData{key: keyB}, 3))
+	assert.Nil(t, lru.Put(&internalData{key: keyC}, 8))
+	assert.Nil(t, lru.Put(&internalData{key: []byte("d")}, 0))
+	assert.Equal(t, 2, len(lru.sparse))
+	assert.Equal(t, 2, lru.FrameOccupancy()[3])
+	assert.Equal(t, 11, len(lru.FrameOccupancy()))
+	assert.NoError(t, lru.Verify())
+
+	// Frame is released when the last node leaves
+	assert.NotNil(t, lru.Delete(&keyC))
+	assert.Equal(t, 1, len(lru.sparse))
+
+	// Clone keeps sparse frames
+	c := lru.Clone()
+	assert.Nil(t, c.frames)
+	assert.Equal(t, 1, len(c.sparse))
+	assert.NoError(t, c.Verify())
+
+	assert.Nil(t, lru.Resize(100))
+	assert.Nil(t, lru.frames)
+	assert.NoError(t, lru.Verify())
+	assert.Equal(t, 0, len(*lru.Prune(3)))
+	assert.Equal(t, 2, len(*lru.Prune(1)))
+	assert.Equal(t, 0, len(lru.sparse))
+	assert.Equal(t, 1, lru.Size())
+	assert.NoError(t, lru.Verify())
+
+	// Frame emptied by PruneFilter is released, too
+	assert.Nil(t, lru.Put(&internalData{key: keyA}, 1))
+	lru.PruneFilter(2, func(LruData) bool { return true })
+	assert.Equal(t, 1, len(lru.sparse))
+	assert.NoError(t, lru.Verify())
+}
+
+func benchmarkFrames(b *testing.B, options ...Option) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		lru := New(1<<22, options...)
+		for j := 0; j < 16; j++ {
+			lru.Put(&internalData{key: []byte(fmt.Sprint(j))}, tick(j+1)<<16)
+		}
+	}
+}
+
+func BenchmarkDenseFramesLargeMaxTick(b *testing.B) {
+	benchmarkFrames(b)
+}
+
+func BenchmarkSparseFramesLargeMaxTick(b *testing.B) {
+	benchmarkFrames(b, WithSparseFrames())
+}
+
+func TestNewWithResolution(t *testing.T) {
+	_, err := NewWithResolution(10, 50)
+	assert.Equal(t, ErrInvalidFrames, err)
+	_, err = NewWithResolution(10, 0)
+	assert.Equal(t, ErrInvalidFrames, err)
+
+	lru, err := NewWithResolution(10, 44)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, int(lru.lanes))
+	assert.Equal(t, 44, len(lru.frames))
+	assert.Equal(t, 1, int(New(10).lanes))
+
+	// Data objects put in distinct lanes land in distinct frames
+	keys := []string{"a", "b", "c", "d"}
+	for _, k := range keys {
+		assert.Nil(t, lru.Put(&internalData{key: []byte(k)}, 2))
+		lru.PruneStep()
+	}
+	assert.Nil(t, lru.Put(&internalData{key: []byte("e")}, 1))
+	seen := map[*frame]int{}
+	lru.walk(func(n *node) bool {
+		seen[lru.frameOf(n)]++
+		return true
+	})
+	assert.Equal(t, 4, len(seen))
+	assert.Equal(t, 2, lru.getFrame(2, 0).count())
+	assert.Equal(t, 5, lru.CountExpiringAt(2))
+	assert.Equal(t, 11, len(lru.FrameOccupancy()))
+	assert.NoError(t, lru.Verify())
+
+	// Each data object is pruned after its TTL in lanes
+	assert.Equal(t, 1, int(lru.CurrentTick()))
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, 0, len(*lru.PruneStep()))
+	}
+	assert.Equal(t, 2, int(lru.CurrentTick()))
+	assert.Equal(t, 2, len(*lru.PruneStep()))
+	assert.Equal(t, 1, len(*lru.PruneStep()))
+	assert.Equal(t, 2, lru.Size())
+	assert.NoError(t, lru.Verify())
+
+	// Prune in the middle of a tick prunes the rest of lanes at once
+	assert.Equal(t, 2, len(*lru.Prune(1)))
+	assert.Equal(t, 3, int(lru.CurrentTick()))
+	assert.Equal(t, 0, int(lru.phase))
+
+	// Resize and sparse frames keep lanes
+	assert.Nil(t, lru.Resize(5))
+	assert.Equal(t, 24, len(lru.frames))
+	sparse, err := NewWithResolution(10, 44, WithSparseFrames())
+	assert.NoError(t, err)
+	assert.Nil(t, sparse.Put(&internalData{key: []byte("a")}, 2))
+	sparse.PruneStep()
+	assert.Nil(t, sparse.Put(&internalData{key: []byte("b")}, 2))
+	assert.Equal(t, 2, len(sparse.sparse))
+	assert.Equal(t, 2, len(*sparse.Prune(3)))
+	assert.Equal(t, 0, len(sparse.sparse))
+}
+
+func TestShardedTryPutContended(t *testing.T) {
+	lru := NewSharded(2, 12)
+	key := []byte("a")
+	shard := lru.getShard(&key)
+
+	shard.mutex.Lock()
+	ok, err := lru.TryPut(&internalData{key: key}, 2)
+	shard.mutex.Unlock()
+	assert.False(t, ok)
+	assert.NoError(t, err)
+	assert.False(t, lru.Contains(&key))
+
+	shard.mutex.RLock()
+	ok, err = lru.TryPut(&internalData{key: key}, 2)
+	shard.mutex.RUnlock()
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	ok, err = lru.TryPut(&internalData{key: key}, 2)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.True(t, lru.Contains(&key))
+}
+
+func TestSyncComputeStraggler(t *testing.T) {
+	lru := NewSync(12)
+	key := []byte("a")
+	called := 0
+	loader := func() LruData {
+		called++
+		return &internalData{key: key}
+	}
+
+	// Goroutine missed the key before the leader stored it and finished
+	assert.Nil(t, lru.Get(&key))
+	stored := &internalData{key: key}
+	assert.Nil(t, lru.Put(stored, 5))
+	assert.True(t, stored == lru.compute(&key, 5, loader))
+	assert.Equal(t, 0, called)
+
+	// Miss is counted once
+	other := []byte("b")
+	assert.NotNil(t, lru.GetOrCompute(&other, 5, loader))
+	assert.Equal(t, 1, called)
+	assert.Equal(t, uint64(2), lru.Stats().Misses)
+}
@@ -1,7 +1,13 @@
 package lrumap_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/m-mizutani/lrumap"
 	"github.com/stretchr/testify/assert"
@@ -54,3 +60,2045 @@ func TestBasicScenario(t *testing.T) {
 	assert.Nil(t, lru.Get(&key1))
 
 }
+
+func TestDuplicatedKey(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	data1 := testData{data: key}
+	data2 := testData{data: key}
+
+	assert.Nil(t, lru.Put(&data1, 2))
+	assert.Equal(t, 1, lru.Size())
+
+	// Put with same key must be rejected
+	assert.NotNil(t, lru.Put(&data2, 2))
+	assert.Equal(t, 1, lru.Size())
+	assert.True(t, &data1 == lru.Get(&key))
+}
+
+func TestDelete(t *testing.T) {
+	lru := lrumap.New(12)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+	key3 := []byte("123")
+	key4 := []byte("not found")
+	data1 := testData{data: key1}
+	data2 := testData{data: key2}
+	data3 := testData{data: key3}
+
+	// All data are linked in same frame. data3 is head of the frame.
+	assert.Nil(t, lru.Put(&data1, 2))
+	assert.Nil(t, lru.Put(&data2, 2))
+	assert.Nil(t, lru.Put(&data3, 2))
+	assert.Equal(t, 3, lru.Size())
+
+	// Delete head of frame
+	assert.True(t, &data3 == lru.Delete(&key3))
+	assert.Equal(t, 2, lru.Size())
+	assert.Nil(t, lru.Get(&key3))
+
+	// Delete missing key
+	assert.Nil(t, lru.Delete(&key4))
+	assert.Nil(t, lru.Delete(&key3))
+	assert.Equal(t, 2, lru.Size())
+
+	// Delete mid-chain node
+	assert.Nil(t, lru.Put(&data3, 2))
+	assert.True(t, &data2 == lru.Delete(&key2))
+	assert.Equal(t, 2, lru.Size())
+	assert.Nil(t, lru.Get(&key2))
+	assert.NotNil(t, lru.Get(&key1))
+	assert.NotNil(t, lru.Get(&key3))
+
+	// Deleted data must not be pruned
+	pruned := lru.Prune(3)
+	assert.Equal(t, 2, len(*pruned))
+	assert.Contains(t, *pruned, &data1)
+	assert.Contains(t, *pruned, &data3)
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestContains(t *testing.T) {
+	lru := lrumap.New(12)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+
+	// Empty table
+	assert.False(t, lru.Contains(&key1))
+
+	assert.Nil(t, lru.Put(&testData{data: key1}, 2))
+
+	testCases := []struct {
+		key    []byte
+		exists bool
+	}{
+		{key1, true},
+		{key2, false},
+		{[]byte(""), false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.exists, lru.Contains(&tc.key), string(tc.key))
+	}
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	key := []byte("abc")
+
+	// Fixed schedule by default
+	fixed := lrumap.New(12)
+	assert.Nil(t, fixed.Put(&testData{data: key}, 2))
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, 0, len(*fixed.Prune(1)))
+		assert.NotNil(t, fixed.Get(&key))
+	}
+	assert.Equal(t, 1, len(*fixed.Prune(1)))
+	assert.Nil(t, fixed.Get(&key))
+
+	// Get refreshes TTL in sliding mode
+	lru := lrumap.New(12, lrumap.WithSlidingExpiration())
+	assert.Nil(t, lru.Put(&testData{data: key}, 2))
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, 0, len(*lru.Prune(1)))
+		assert.NotNil(t, lru.Get(&key))
+	}
+
+	// Entry expires after TTL from last Get
+	assert.Equal(t, 0, len(*lru.Prune(2)))
+	assert.Equal(t, 1, len(*lru.Prune(1)))
+	assert.Nil(t, lru.Get(&key))
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestRemainingTTL(t *testing.T) {
+	lru := lrumap.New(12)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+
+	_, ok := lru.RemainingTTL(&key1)
+	assert.False(t, ok)
+
+	assert.Equal(t, 0, len(*lru.Prune(3)))
+	assert.Nil(t, lru.Put(&testData{data: key1}, 5))
+
+	ttl, ok := lru.RemainingTTL(&key1)
+	assert.True(t, ok)
+	assert.Equal(t, 5, int(ttl))
+
+	lru.Prune(2)
+	ttl, ok = lru.RemainingTTL(&key1)
+	assert.True(t, ok)
+	assert.Equal(t, 3, int(ttl))
+
+	// Pruned at the tick when remaining TTL is 0
+	lru.Prune(3)
+	ttl, ok = lru.RemainingTTL(&key1)
+	assert.True(t, ok)
+	assert.Equal(t, 0, int(ttl))
+	assert.Equal(t, 1, len(*lru.Prune(1)))
+
+	_, ok = lru.RemainingTTL(&key1)
+	assert.False(t, ok)
+	_, ok = lru.RemainingTTL(&key2)
+	assert.False(t, ok)
+}
+
+func TestClear(t *testing.T) {
+	lru := lrumap.New(12)
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	assert.Nil(t, lru.Put(&testData{data: keys[0]}, 1))
+	assert.Nil(t, lru.Put(&testData{data: keys[1]}, 2))
+	assert.Nil(t, lru.Put(&testData{data: keys[2]}, 3))
+	lru.Prune(2)
+	assert.Equal(t, 2, lru.Size())
+
+	lru.Clear()
+	assert.Equal(t, 0, lru.Size())
+	for i := range keys {
+		assert.Nil(t, lru.Get(&keys[i]))
+	}
+	assert.Equal(t, 0, len(*lru.Prune(12)))
+
+	// Put and Get still work after Clear
+	assert.Nil(t, lru.Put(&testData{data: keys[0]}, 2))
+	assert.Equal(t, 1, lru.Size())
+	assert.NotNil(t, lru.Get(&keys[0]))
+	assert.Equal(t, 0, len(*lru.Prune(2)))
+	assert.Equal(t, 1, len(*lru.Prune(1)))
+}
+
+func TestKeys(t *testing.T) {
+	lru := lrumap.New(12)
+	assert.Equal(t, 0, len(lru.Keys()))
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	for i := range keys {
+		assert.Nil(t, lru.Put(&testData{data: keys[i]}, 2))
+	}
+
+	res := lru.Keys()
+	assert.Equal(t, lru.Size(), len(res))
+	assert.ElementsMatch(t, keys, res)
+
+	// Modifying returned key does not affect internal key
+	res[0][0] = 'x'
+	assert.Equal(t, lru.Size(), len(lru.Keys()))
+	for i := range keys {
+		assert.NotNil(t, lru.Get(&keys[i]))
+	}
+}
+
+func TestForEach(t *testing.T) {
+	lru := lrumap.New(12)
+
+	// Empty map
+	called := 0
+	lru.ForEach(func(d lrumap.LruData) bool {
+		called++
+		return true
+	})
+	assert.Equal(t, 0, called)
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	for i := range keys {
+		assert.Nil(t, lru.Put(&testData{data: keys[i]}, 2))
+	}
+
+	// Full traversal
+	var visited [][]byte
+	lru.ForEach(func(d lrumap.LruData) bool {
+		visited = append(visited, *d.Key())
+		return true
+	})
+	assert.ElementsMatch(t, keys, visited)
+
+	// Early termination
+	called = 0
+	lru.ForEach(func(d lrumap.LruData) bool {
+		called++
+		return called < 2
+	})
+	assert.Equal(t, 2, called)
+}
+
+func TestOnEvict(t *testing.T) {
+	var evicted []lrumap.LruData
+	lru := lrumap.New(12, lrumap.WithOnEvict(func(d lrumap.LruData) {
+		evicted = append(evicted, d)
+	}))
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	for i := range keys {
+		assert.Nil(t, lru.Put(&testData{data: keys[i]}, 2))
+	}
+
+	// Callback is not called for Delete by default
+	assert.NotNil(t, lru.Delete(&keys[0]))
+	assert.Equal(t, 0, len(evicted))
+
+	pruned := lru.Prune(3)
+	assert.Equal(t, 3, len(*pruned))
+	assert.Equal(t, len(*pruned), len(evicted))
+	assert.ElementsMatch(t, *pruned, evicted)
+}
+
+func TestOnEvictWithDelete(t *testing.T) {
+	called := 0
+	lru := lrumap.New(12,
+		lrumap.WithOnEvict(func(d lrumap.LruData) { called++ }),
+		lrumap.WithEvictOnDelete())
+
+	key := []byte("a")
+	assert.Nil(t, lru.Put(&testData{data: key}, 2))
+	assert.NotNil(t, lru.Delete(&key))
+	assert.Equal(t, 1, called)
+
+	// Missing key does not call the callback
+	assert.Nil(t, lru.Delete(&key))
+	assert.Equal(t, 1, called)
+}
+
+func TestUpsert(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	data1 := testData{data: key}
+	data2 := testData{data: []byte("abc")}
+
+	// Behaves like Put for new key
+	assert.Nil(t, lru.Upsert(&data1, 2))
+	assert.Equal(t, 1, lru.Size())
+	assert.True(t, &data1 == lru.Get(&key))
+	assert.NotNil(t, lru.Upsert(&data1, 13))
+
+	lru.Prune(2)
+
+	// Replace value and reset TTL
+	assert.Nil(t, lru.Upsert(&data2, 3))
+	assert.Equal(t, 1, lru.Size())
+	assert.True(t, &data2 == lru.Get(&key))
+	ttl, ok := lru.RemainingTTL(&key)
+	assert.True(t, ok)
+	assert.Equal(t, 3, int(ttl))
+
+	// Not pruned at the original schedule
+	assert.Equal(t, 0, len(*lru.Prune(3)))
+	pruned := lru.Prune(1)
+	assert.Equal(t, 1, len(*pruned))
+	assert.True(t, &data2 == (*pruned)[0])
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestGetOrPut(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	data1 := testData{data: key}
+	data2 := testData{data: []byte("abc")}
+
+	// Stored
+	res, stored := lru.GetOrPut(&data1, 2)
+	assert.True(t, stored)
+	assert.True(t, &data1 == res)
+	assert.Equal(t, 1, lru.Size())
+
+	// Loaded
+	res, stored = lru.GetOrPut(&data2, 5)
+	assert.False(t, stored)
+	assert.True(t, &data1 == res)
+	assert.Equal(t, 1, lru.Size())
+
+	// TTL of loaded data object is not changed
+	ttl, _ := lru.RemainingTTL(&key)
+	assert.Equal(t, 2, int(ttl))
+
+	// Over maxTick
+	key2 := []byte("xyz")
+	res, stored = lru.GetOrPut(&testData{data: key2}, 13)
+	assert.False(t, stored)
+	assert.Nil(t, res)
+	assert.False(t, lru.Contains(&key2))
+}
+
+func TestMaxEntries(t *testing.T) {
+	var evicted []lrumap.LruData
+	lru := lrumap.New(12, lrumap.WithMaxEntries(2), lrumap.WithOnEvict(func(d lrumap.LruData) {
+		evicted = append(evicted, d)
+	}))
+	keyA, keyB, keyC := []byte("a"), []byte("b"), []byte("c")
+	dataA := testData{data: keyA}
+	dataB := testData{data: keyB}
+
+	assert.Nil(t, lru.Put(&dataA, 5))
+	assert.Nil(t, lru.Put(&dataB, 5))
+	assert.Equal(t, 0, len(evicted))
+
+	// Get makes "a" most recently used, then "b" is evicted
+	assert.NotNil(t, lru.Get(&keyA))
+	assert.Nil(t, lru.Put(&testData{data: keyC}, 5))
+	assert.Equal(t, 2, lru.Size())
+	assert.Equal(t, []lrumap.LruData{&dataB}, evicted)
+	assert.Nil(t, lru.Get(&keyB))
+	assert.NotNil(t, lru.Get(&keyA))
+	assert.NotNil(t, lru.Get(&keyC))
+
+	// Evicted data object is not pruned
+	assert.Equal(t, 2, len(*lru.Prune(6)))
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestMaxEntriesAfterRemoval(t *testing.T) {
+	lru := lrumap.New(12, lrumap.WithMaxEntries(2))
+	keyA, keyB, keyC, keyD := []byte("a"), []byte("b"), []byte("c"), []byte("d")
+
+	// Pruned and deleted data objects leave recency order
+	assert.Nil(t, lru.Put(&testData{data: keyA}, 1))
+	assert.Nil(t, lru.Put(&testData{data: keyB}, 5))
+	lru.Prune(2)
+	assert.NotNil(t, lru.Delete(&keyB))
+	assert.Equal(t, 0, lru.Size())
+
+	assert.Nil(t, lru.Put(&testData{data: keyB}, 5))
+	assert.Nil(t, lru.Put(&testData{data: keyC}, 5))
+	assert.Equal(t, 2, lru.Size())
+	assert.Nil(t, lru.Put(&testData{data: keyD}, 5))
+	assert.Equal(t, 2, lru.Size())
+	assert.False(t, lru.Contains(&keyB))
+	assert.True(t, lru.Contains(&keyC))
+	assert.True(t, lru.Contains(&keyD))
+}
+
+func TestWithHasher(t *testing.T) {
+	// All keys are chained in one bucket
+	lru := lrumap.New(12, lrumap.WithHasher(func(key *[]byte) uint64 {
+		return 0
+	}))
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	for i := range keys {
+		assert.Nil(t, lru.Put(&testData{data: keys[i]}, 2))
+	}
+	assert.NotNil(t, lru.Put(&testData{data: []byte("c")}, 2))
+	assert.Equal(t, 3, lru.Size())
+
+	for i := range keys {
+		d := lru.Get(&keys[i])
+		assert.NotNil(t, d)
+		assert.Equal(t, keys[i], *d.Key())
+	}
+
+	assert.NotNil(t, lru.Delete(&keys[1]))
+	assert.False(t, lru.Contains(&keys[1]))
+	assert.True(t, lru.Contains(&keys[0]))
+	assert.True(t, lru.Contains(&keys[2]))
+
+	assert.Equal(t, 2, len(*lru.Prune(3)))
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestPruneOverFrames(t *testing.T) {
+	lru := lrumap.New(12)
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	assert.Nil(t, lru.Put(&testData{data: keys[0]}, 1))
+	assert.Nil(t, lru.Put(&testData{data: keys[1]}, 2))
+	assert.Nil(t, lru.Put(&testData{data: keys[2]}, 6))
+	assert.Nil(t, lru.Put(&testData{data: keys[3]}, 12))
+
+	pruned := lru.Prune(30)
+	assert.Equal(t, 4, len(*pruned))
+	assert.Equal(t, 0, lru.Size())
+	assert.Equal(t, uint64(4), lru.Stats().Prunes)
+
+	// Current tick is advanced by full progress
+	assert.Nil(t, lru.Put(&testData{data: keys[0]}, 2))
+	assert.Equal(t, 0, len(*lru.Prune(2)))
+	assert.Equal(t, 1, len(*lru.Prune(1)))
+}
+
+func TestCurrentTick(t *testing.T) {
+	lru := lrumap.New(12)
+	assert.Equal(t, 0, int(lru.CurrentTick()))
+	assert.Equal(t, 12, int(lru.MaxTick()))
+
+	lru.Prune(1)
+	assert.Equal(t, 1, int(lru.CurrentTick()))
+	lru.Prune(30)
+	assert.Equal(t, 31, int(lru.CurrentTick()))
+	assert.Equal(t, 12, int(lru.MaxTick()))
+}
+
+func TestPutAt(t *testing.T) {
+	lru := lrumap.New(12)
+	lru.Prune(5)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+
+	// Past or current tick
+	assert.NotNil(t, lru.PutAt(&testData{data: key1}, 4))
+	assert.NotNil(t, lru.PutAt(&testData{data: key1}, 5))
+	// Beyond horizon
+	assert.NotNil(t, lru.PutAt(&testData{data: key1}, 18))
+	assert.Equal(t, 0, lru.Size())
+
+	// Boundaries
+	assert.Nil(t, lru.PutAt(&testData{data: key1}, 6))
+	assert.Nil(t, lru.PutAt(&testData{data: key2}, 17))
+	ttl, _ := lru.RemainingTTL(&key2)
+	assert.Equal(t, 12, int(ttl))
+
+	assert.Equal(t, 1, len(*lru.Prune(2)))
+	assert.False(t, lru.Contains(&key1))
+	assert.Equal(t, 0, len(*lru.Prune(10)))
+	assert.Equal(t, 1, len(*lru.Prune(1)))
+	assert.False(t, lru.Contains(&key2))
+}
+
+func TestPutBatch(t *testing.T) {
+	lru := lrumap.New(12)
+	errs := lru.PutBatch([]lrumap.PutItem{
+		{Obj: &testData{data: []byte("a")}, TTL: 1},
+		{Obj: &testData{data: []byte("b")}, TTL: 13},
+		{Obj: &testData{data: []byte("c")}, TTL: 3},
+		{Obj: &testData{data: []byte("a")}, TTL: 2},
+		{Obj: &testData{data: []byte("d")}, TTL: 12},
+	})
+
+	assert.Equal(t, 5, len(errs))
+	assert.Nil(t, errs[0])
+	assert.NotNil(t, errs[1])
+	assert.Nil(t, errs[2])
+	assert.NotNil(t, errs[3])
+	assert.Nil(t, errs[4])
+	assert.Equal(t, 3, lru.Size())
+}
+
+func TestGetMulti(t *testing.T) {
+	lru := lrumap.New(12)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+	key3 := []byte("123")
+	data1 := testData{data: key1}
+	data3 := testData{data: key3}
+	assert.Nil(t, lru.Put(&data1, 2))
+	assert.Nil(t, lru.Put(&data3, 2))
+
+	res := lru.GetMulti([]*[]byte{&key1, &key2, &key3})
+	assert.Equal(t, 3, len(res))
+	assert.True(t, &data1 == res[0])
+	assert.Nil(t, res[1])
+	assert.True(t, &data3 == res[2])
+
+	assert.Equal(t, 0, len(lru.GetMulti(nil)))
+}
+
+func TestClone(t *testing.T) {
+	lru := lrumap.New(12)
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	assert.Nil(t, lru.Put(&testData{data: keys[0]}, 1))
+	assert.Nil(t, lru.Put(&testData{data: keys[1]}, 3))
+	assert.Nil(t, lru.Put(&testData{data: keys[2]}, 3))
+	lru.Prune(1)
+
+	clone := lru.Clone()
+	assert.Equal(t, lru.Size(), clone.Size())
+	assert.Equal(t, lru.CurrentTick(), clone.CurrentTick())
+	assert.ElementsMatch(t, lru.Keys(), clone.Keys())
+	// Data objects are shared
+	assert.True(t, lru.Get(&keys[1]) == clone.Get(&keys[1]))
+
+	// Modify clone
+	assert.NotNil(t, clone.Delete(&keys[1]))
+	assert.Nil(t, clone.Put(&testData{data: []byte("d")}, 5))
+	assert.Equal(t, 2, len(*clone.Prune(3)))
+	assert.Equal(t, 1, clone.Size())
+
+	// Original is not changed
+	assert.Equal(t, 3, lru.Size())
+	assert.Equal(t, 1, int(lru.CurrentTick()))
+	for i := range keys {
+		assert.True(t, lru.Contains(&keys[i]))
+	}
+	assert.Equal(t, 1, len(*lru.Prune(1)))
+	assert.Equal(t, 2, len(*lru.Prune(2)))
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestCloneWithMaxEntries(t *testing.T) {
+	lru := lrumap.New(12, lrumap.WithMaxEntries(2))
+	keyA, keyB := []byte("a"), []byte("b")
+	assert.Nil(t, lru.Put(&testData{data: keyA}, 5))
+	assert.Nil(t, lru.Put(&testData{data: keyB}, 5))
+
+	// Recency order is also copied
+	clone := lru.Clone()
+	assert.Nil(t, clone.Put(&testData{data: []byte("c")}, 5))
+	assert.False(t, clone.Contains(&keyA))
+	assert.True(t, clone.Contains(&keyB))
+	assert.True(t, lru.Contains(&keyA))
+}
+
+func TestEmpty(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	assert.True(t, lru.Empty())
+	assert.Nil(t, lru.Put(&testData{data: key}, 2))
+	assert.False(t, lru.Empty())
+	assert.NotNil(t, lru.Delete(&key))
+	assert.True(t, lru.Empty())
+}
+
+func TestPruneCount(t *testing.T) {
+	evicted := 0
+	lru := lrumap.New(12, lrumap.WithOnEvict(func(d lrumap.LruData) { evicted++ }))
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	assert.Nil(t, lru.Put(&testData{data: keys[0]}, 1))
+	assert.Nil(t, lru.Put(&testData{data: keys[1]}, 1))
+	assert.Nil(t, lru.Put(&testData{data: keys[2]}, 4))
+
+	assert.Equal(t, 2, lru.PruneCount(2))
+	assert.Equal(t, 2, evicted)
+	assert.Equal(t, 1, lru.Size())
+	assert.Equal(t, 2, int(lru.CurrentTick()))
+	assert.False(t, lru.Contains(&keys[0]))
+	assert.True(t, lru.Contains(&keys[2]))
+
+	assert.Equal(t, 0, lru.PruneCount(2))
+	assert.Equal(t, 1, lru.PruneCount(1))
+	assert.Equal(t, 3, evicted)
+	assert.Equal(t, 0, lru.Size())
+}
+
+func benchmarkPrune(b *testing.B, prune func(lru *lrumap.LruMap)) {
+	var data []testData
+	for i := 0; i < 1024; i++ {
+		data = append(data, testData{data: []byte(fmt.Sprintf("key%d", i))})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		lru := lrumap.New(12)
+		for j := range data {
+			if err := lru.Put(&data[j], 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StartTimer()
+
+		prune(lru)
+	}
+}
+
+func BenchmarkPrune(b *testing.B) {
+	benchmarkPrune(b, func(lru *lrumap.LruMap) { lru.Prune(2) })
+}
+
+func BenchmarkPruneCount(b *testing.B) {
+	benchmarkPrune(b, func(lru *lrumap.LruMap) { lru.PruneCount(2) })
+}
+
+func BenchmarkPutPrune(b *testing.B) {
+	var data []testData
+	for i := 0; i < 1024; i++ {
+		data = append(data, testData{data: []byte(fmt.Sprintf("key%d", i))})
+	}
+	lru := lrumap.New(12)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range data {
+			if err := lru.Put(&data[j], 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+		lru.PruneCount(2)
+	}
+}
+
+func TestWithInitialCapacity(t *testing.T) {
+	lru := lrumap.New(12, lrumap.WithInitialCapacity(128))
+	key := []byte("abc")
+	assert.Nil(t, lru.Put(&testData{data: key}, 2))
+	assert.NotNil(t, lru.Get(&key))
+
+	lru.Clear()
+	assert.Equal(t, 0, lru.Size())
+	assert.Nil(t, lru.Put(&testData{data: key}, 2))
+	assert.NotNil(t, lru.Get(&key))
+}
+
+func benchmarkPutN(b *testing.B, options ...lrumap.Option) {
+	var data []testData
+	for i := 0; i < 4096; i++ {
+		data = append(data, testData{data: []byte(fmt.Sprintf("key%d", i))})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lru := lrumap.New(12, options...)
+		for j := range data {
+			if err := lru.Put(&data[j], 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkPutWithoutCapacity(b *testing.B) {
+	benchmarkPutN(b)
+}
+
+func BenchmarkPutWithCapacity(b *testing.B) {
+	benchmarkPutN(b, lrumap.WithInitialCapacity(4096))
+}
+
+func TestSetTTL(t *testing.T) {
+	lru := lrumap.New(12)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+	key3 := []byte("123")
+	assert.Nil(t, lru.Put(&testData{data: key1}, 2))
+	assert.Nil(t, lru.Put(&testData{data: key2}, 8))
+
+	// Missing key and over maxTick
+	assert.False(t, lru.SetTTL(&key3, 2))
+	assert.False(t, lru.SetTTL(&key1, 13))
+
+	// Extend
+	lru.Prune(1)
+	assert.True(t, lru.SetTTL(&key1, 5))
+	ttl, _ := lru.RemainingTTL(&key1)
+	assert.Equal(t, 5, int(ttl))
+
+	// Shorten
+	assert.True(t, lru.SetTTL(&key2, 1))
+
+	assert.Equal(t, 0, len(*lru.Prune(1)))
+	pruned := lru.Prune(1)
+	assert.Equal(t, 1, len(*pruned))
+	assert.Equal(t, key2, *(*pruned)[0].Key())
+
+	assert.Equal(t, 0, len(*lru.Prune(3)))
+	pruned = lru.Prune(1)
+	assert.Equal(t, 1, len(*pruned))
+	assert.Equal(t, key1, *(*pruned)[0].Key())
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestZeroTTL(t *testing.T) {
+	lru := lrumap.New(12, lrumap.WithSlidingExpiration())
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+	assert.Nil(t, lru.Put(&testData{data: key1}, 0))
+	assert.Nil(t, lru.Put(&testData{data: key2}, 3))
+	assert.NotNil(t, lru.Put(&testData{data: key1}, 0))
+	assert.Equal(t, 2, lru.Size())
+
+	// Data object of ttl 0 is never pruned
+	pruned := lru.Prune(12)
+	assert.Equal(t, 1, len(*pruned))
+	assert.Equal(t, key2, *(*pruned)[0].Key())
+	assert.Equal(t, 0, len(*lru.Prune(100)))
+	assert.NotNil(t, lru.Get(&key1))
+	assert.Equal(t, 1, lru.Size())
+	ttl, ok := lru.RemainingTTL(&key1)
+	assert.True(t, ok)
+	assert.Equal(t, 0, int(ttl))
+
+	// SetTTL moves it to the wheel and back
+	assert.True(t, lru.SetTTL(&key1, 2))
+	assert.True(t, lru.SetTTL(&key1, 0))
+	assert.Equal(t, 0, len(*lru.Prune(12)))
+
+	assert.NotNil(t, lru.Delete(&key1))
+	assert.Equal(t, 0, lru.Size())
+
+	// Clear drops it, too
+	assert.Nil(t, lru.Put(&testData{data: key1}, 0))
+	lru.Clear()
+	assert.Equal(t, 0, lru.Size())
+	assert.Nil(t, lru.Get(&key1))
+}
+
+func TestErrors(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	assert.Nil(t, lru.Put(&testData{data: key}, 2))
+
+	err := lru.Put(&testData{data: key}, 2)
+	assert.True(t, errors.Is(err, lrumap.ErrDuplicateKey))
+	assert.Equal(t, "Duplicated key", err.Error())
+
+	err = lru.Put(&testData{data: []byte("xyz")}, 13)
+	assert.True(t, errors.Is(err, lrumap.ErrTTLTooLarge))
+	assert.Equal(t, "TTL is over maxTick", err.Error())
+
+	err = lru.PutAt(&testData{data: []byte("xyz")}, 13)
+	assert.True(t, errors.Is(err, lrumap.ErrTTLTooLarge))
+	assert.True(t, errors.Is(lru.Upsert(&testData{data: key}, 13), lrumap.ErrTTLTooLarge))
+}
+
+func TestGetAndDelete(t *testing.T) {
+	lru := lrumap.New(12)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+	data := testData{data: key1}
+	assert.Nil(t, lru.Put(&data, 2))
+
+	assert.Nil(t, lru.GetAndDelete(&key2))
+	assert.True(t, &data == lru.GetAndDelete(&key1))
+	assert.Nil(t, lru.GetAndDelete(&key1))
+	assert.Equal(t, 0, lru.Size())
+	assert.Equal(t, 0, len(*lru.Prune(3)))
+
+	stats := lru.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+}
+
+func TestGetOrCompute(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	called := 0
+	loader := func() lrumap.LruData {
+		called++
+		return &testData{data: []byte("abc")}
+	}
+
+	// Miss
+	d1 := lru.GetOrCompute(&key, 2, loader)
+	assert.NotNil(t, d1)
+	assert.Equal(t, 1, called)
+	assert.Equal(t, 1, lru.Size())
+
+	// Hit
+	d2 := lru.GetOrCompute(&key, 2, loader)
+	assert.True(t, d1 == d2)
+	assert.Equal(t, 1, called)
+
+	// Loader returns nil
+	key2 := []byte("xyz")
+	assert.Nil(t, lru.GetOrCompute(&key2, 2, func() lrumap.LruData { return nil }))
+	assert.Equal(t, 1, lru.Size())
+}
+
+func TestDeleteMatching(t *testing.T) {
+	lru := lrumap.New(12)
+	var expected [][]byte
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("tenant%d-%d", i%2, i))
+		assert.Nil(t, lru.Put(&testData{data: key}, 2))
+		if i%2 == 1 {
+			expected = append(expected, key)
+		}
+	}
+
+	removed := lru.DeleteMatching(func(d lrumap.LruData) bool {
+		return bytes.HasPrefix(*d.Key(), []byte("tenant0-"))
+	})
+	assert.Equal(t, 5, removed)
+	assert.Equal(t, 5, lru.Size())
+	assert.ElementsMatch(t, expected, lru.Keys())
+
+	assert.Equal(t, 0, lru.DeleteMatching(func(d lrumap.LruData) bool { return false }))
+
+	// Frames are kept consistent
+	assert.Equal(t, 5, len(*lru.Prune(3)))
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestEntries(t *testing.T) {
+	lru := lrumap.New(12)
+	assert.Equal(t, 0, len(lru.Entries()))
+
+	var inserted []lrumap.LruData
+	for i := 0; i < 5; i++ {
+		d := &testData{data: []byte(fmt.Sprintf("key%d", i))}
+		inserted = append(inserted, d)
+		assert.Nil(t, lru.Put(d, 0))
+	}
+
+	entries := lru.Entries()
+	assert.Equal(t, lru.Size(), len(entries))
+	for _, d := range inserted {
+		assert.Contains(t, entries, d)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dst := lrumap.New(12)
+	src := lrumap.New(20)
+	src.Prune(5)
+
+	keyA, keyB, keyC, keyD := []byte("a"), []byte("b"), []byte("c"), []byte("d")
+	dataA1 := testData{data: keyA}
+	dataA2 := testData{data: keyA}
+	assert.Nil(t, dst.Put(&dataA1, 2))
+	assert.Nil(t, src.Put(&dataA2, 10))
+	assert.Nil(t, src.Put(&testData{data: keyB}, 3))
+	assert.Nil(t, src.Put(&testData{data: keyC}, 20))
+	assert.Nil(t, src.Put(&testData{data: keyD}, 0))
+
+	var conflicts int
+	assert.Nil(t, dst.Merge(src, func(existing, incoming lrumap.LruData) lrumap.LruData {
+		conflicts++
+		assert.True(t, &dataA1 == existing)
+		assert.True(t, &dataA2 == incoming)
+		return incoming
+	}))
+
+	assert.Equal(t, 1, conflicts)
+	assert.Equal(t, 4, dst.Size())
+	assert.Equal(t, 4, src.Size())
+
+	// Conflicted data object is replaced keeping TTL of existing one
+	assert.True(t, &dataA2 == dst.Get(&keyA))
+	ttl, _ := dst.RemainingTTL(&keyA)
+	assert.Equal(t, 2, int(ttl))
+
+	// Remaining TTL is translated and clamped
+	ttl, _ = dst.RemainingTTL(&keyB)
+	assert.Equal(t, 3, int(ttl))
+	ttl, _ = dst.RemainingTTL(&keyC)
+	assert.Equal(t, 12, int(ttl))
+
+	assert.Equal(t, 2, len(*dst.Prune(12)))
+	assert.Equal(t, 1, len(*dst.Prune(1)))
+	assert.Equal(t, 1, dst.Size())
+	assert.True(t, dst.Contains(&keyD))
+}
+
+func TestMergeKeepExisting(t *testing.T) {
+	dst := lrumap.New(12)
+	src := lrumap.New(12)
+	key := []byte("a")
+	data := testData{data: key}
+	assert.Nil(t, dst.Put(&data, 2))
+	assert.Nil(t, src.Put(&testData{data: key}, 5))
+
+	assert.Nil(t, dst.Merge(src, nil))
+	assert.True(t, &data == dst.Get(&key))
+	assert.Equal(t, 1, dst.Size())
+}
+
+func TestMergeInvalidConflictResult(t *testing.T) {
+	dst := lrumap.New(12)
+	src := lrumap.New(12)
+	keyA, keyB := []byte("a"), []byte("b")
+	dataA, dataB := &testData{data: keyA}, &testData{data: keyB}
+	assert.Nil(t, dst.Put(dataA, 2))
+	assert.Nil(t, dst.Put(dataB, 2))
+	assert.Nil(t, src.Put(&testData{data: keyA}, 5))
+	assert.Nil(t, src.Put(&testData{data: keyB}, 5))
+
+	// Both results are skipped and existing data objects are kept
+	assert.Error(t, dst.Merge(src, func(existing, incoming lrumap.LruData) lrumap.LruData {
+		if existing == dataA {
+			return nil
+		}
+		return &testData{data: []byte("c")}
+	}))
+	assert.True(t, dataA == dst.Get(&keyA))
+	assert.True(t, dataB == dst.Get(&keyB))
+	assert.Equal(t, 2, dst.Size())
+	assert.NoError(t, dst.Verify())
+}
+
+func TestMergeChainTooLong(t *testing.T) {
+	hasher := lrumap.WithHasher(func(key *[]byte) uint64 {
+		if (*key)[0] == 'y' {
+			return 2
+		}
+		return 1
+	})
+	dst := lrumap.New(12, lrumap.WithMaxChainLength(1), hasher)
+	src := lrumap.New(12, lrumap.WithMaxChainLength(1), hasher)
+	assert.Nil(t, dst.Put(&testData{data: []byte("x1")}, 2))
+	assert.Nil(t, src.Put(&testData{data: []byte("x2")}, 3))
+	assert.Nil(t, src.Put(&testData{data: []byte("y1")}, 0))
+
+	// Data object not inserted is reported and the rest is merged
+	assert.Equal(t, lrumap.ErrChainTooLong, dst.Merge(src, nil))
+	assert.Equal(t, 2, dst.Size())
+	key := []byte("y1")
+	assert.True(t, dst.Contains(&key))
+	key = []byte("x2")
+	assert.False(t, dst.Contains(&key))
+}
+
+func TestPopNext(t *testing.T) {
+	lru := lrumap.New(10)
+	lru.Prune(4)
+	assert.Nil(t, lru.Put(&testData{data: []byte("k7")}, 7))
+	assert.Nil(t, lru.Put(&testData{data: []byte("k2")}, 2))
+	assert.Nil(t, lru.Put(&testData{data: []byte("k10")}, 10))
+	assert.Nil(t, lru.Put(&testData{data: []byte("k5")}, 5))
+	assert.Nil(t, lru.Put(&testData{data: []byte("persistent")}, 0))
+
+	var keys []string
+	for {
+		data, ok := lru.PopNext()
+		if !ok {
+			assert.Nil(t, data)
+			break
+		}
+		keys = append(keys, string(*data.Key()))
+	}
+
+	assert.Equal(t, []string{"k2", "k5", "k7", "k10"}, keys)
+	assert.Equal(t, 1, lru.Size())
+	assert.Equal(t, 0, len(*lru.Prune(10)))
+}
+
+func TestPeekNext(t *testing.T) {
+	lru := lrumap.New(10)
+	assert.Equal(t, 0, len(lru.PeekNext(3)))
+
+	lru.Prune(4)
+	assert.Nil(t, lru.Put(&testData{data: []byte("k7")}, 7))
+	assert.Nil(t, lru.Put(&testData{data: []byte("k2")}, 2))
+	assert.Nil(t, lru.Put(&testData{data: []byte("k10")}, 10))
+	assert.Nil(t, lru.Put(&testData{data: []byte("k5")}, 5))
+	assert.Nil(t, lru.Put(&testData{data: []byte("persistent")}, 0))
+
+	var peeked []string
+	for _, d := range lru.PeekNext(3) {
+		peeked = append(peeked, string(*d.Key()))
+	}
+	assert.Equal(t, []string{"k2", "k5", "k7"}, peeked)
+
+	// Data object that never expires is not returned
+	assert.Equal(t, 4, len(lru.PeekNext(10)))
+	assert.Equal(t, 0, len(lru.PeekNext(0)))
+
+	// Nothing is removed
+	assert.Equal(t, 5, lru.Size())
+	data, _ := lru.PopNext()
+	assert.Equal(t, "k2", string(*data.Key()))
+}
+
+func TestCountExpiring(t *testing.T) {
+	lru := lrumap.New(10)
+	lru.Prune(3)
+	assert.Nil(t, lru.Put(&testData{data: []byte("a")}, 2))
+	assert.Nil(t, lru.Put(&testData{data: []byte("b")}, 2))
+	assert.Nil(t, lru.Put(&testData{data: []byte("c")}, 5))
+	assert.Nil(t, lru.Put(&testData{data: []byte("d")}, 10))
+	assert.Nil(t, lru.Put(&testData{data: []byte("e")}, 0))
+
+	assert.Equal(t, 2, lru.CountExpiringAt(5))
+	assert.Equal(t, 1, lru.CountExpiringAt(8))
+	assert.Equal(t, 1, lru.CountExpiringAt(13))
+	assert.Equal(t, 0, lru.CountExpiringAt(6))
+
+	// Out of horizon
+	assert.Equal(t, 0, lru.CountExpiringAt(2))
+	assert.Equal(t, 0, lru.CountExpiringAt(16))
+
+	assert.Equal(t, 0, lru.CountExpiringWithin(2))
+	assert.Equal(t, 2, lru.CountExpiringWithin(3))
+	assert.Equal(t, 3, lru.CountExpiringWithin(6))
+	assert.Equal(t, 4, lru.CountExpiringWithin(100))
+	assert.Equal(t, 3, len(*lru.Prune(6)))
+}
+
+func TestPutWithOptions(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	data1 := testData{data: key}
+	data2 := testData{data: key}
+	data3 := testData{data: key}
+	assert.Nil(t, lru.PutWithOptions(&data1, 2, lrumap.PutOptions{}))
+
+	// Rejected without overwrite
+	assert.Equal(t, lrumap.ErrDuplicateKey, lru.PutWithOptions(&data2, 5, lrumap.PutOptions{}))
+	assert.True(t, &data1 == lru.Get(&key))
+
+	// Replaced with TTL reset
+	lru.Prune(1)
+	assert.Nil(t, lru.PutWithOptions(&data3, 5, lrumap.PutOptions{Overwrite: true}))
+	assert.True(t, &data3 == lru.Get(&key))
+	assert.Equal(t, 1, lru.Size())
+	ttl, _ := lru.RemainingTTL(&key)
+	assert.Equal(t, 5, int(ttl))
+
+	assert.Equal(t, lrumap.ErrTTLTooLarge, lru.PutWithOptions(&data3, 13, lrumap.PutOptions{Overwrite: true}))
+	assert.Equal(t, 0, len(*lru.Prune(5)))
+	assert.Equal(t, 1, len(*lru.Prune(1)))
+}
+
+func TestFrameOccupancy(t *testing.T) {
+	lru := lrumap.New(4)
+	assert.Equal(t, 4, int(lru.Horizon()))
+	assert.Equal(t, []int{0, 0, 0, 0, 0}, lru.FrameOccupancy())
+
+	lru.Prune(3)
+	assert.Nil(t, lru.Put(&testData{data: []byte("a")}, 1))
+	assert.Nil(t, lru.Put(&testData{data: []byte("b")}, 4))
+	assert.Nil(t, lru.Put(&testData{data: []byte("c")}, 4))
+	assert.Nil(t, lru.Put(&testData{data: []byte("d")}, 0))
+	assert.Equal(t, []int{0, 1, 0, 0, 2}, lru.FrameOccupancy())
+
+	lru.Prune(2)
+	assert.Equal(t, []int{0, 0, 2, 0, 0}, lru.FrameOccupancy())
+}
+
+func TestKeyCopiedOnInsert(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	data := testData{data: key}
+	assert.Nil(t, lru.Put(&data, 2))
+
+	buf := []byte("xyz")
+	_, ok := lru.GetOrPut(&testData{data: buf}, 2)
+	assert.True(t, ok)
+
+	// Reuse backing arrays of the keys
+	copy(key, "zzz")
+	copy(buf, "zzz")
+
+	orig := []byte("abc")
+	assert.True(t, &data == lru.Get(&orig))
+	orig = []byte("xyz")
+	assert.NotNil(t, lru.Get(&orig))
+	orig = []byte("zzz")
+	assert.Nil(t, lru.Get(&orig))
+	assert.ElementsMatch(t, [][]byte{[]byte("abc"), []byte("xyz")}, lru.Keys())
+}
+
+func TestRangeByExpiry(t *testing.T) {
+	lru := lrumap.New(10)
+	lru.Prune(8)
+	assert.Nil(t, lru.Put(&testData{data: []byte("k9")}, 9))
+	assert.Nil(t, lru.Put(&testData{data: []byte("persistent")}, 0))
+	assert.Nil(t, lru.Put(&testData{data: []byte("k1")}, 1))
+	assert.Nil(t, lru.Put(&testData{data: []byte("k6")}, 6))
+	assert.Nil(t, lru.Put(&testData{data: []byte("k3")}, 3))
+
+	var keys []string
+	var ticks []int
+	lru.RangeByExpiry(func(data lrumap.LruData, expireAt uint64) bool {
+		keys = append(keys, string(*data.Key()))
+		ticks = append(ticks, int(expireAt))
+		return true
+	})
+	assert.Equal(t, []string{"k1", "k3", "k6", "k9", "persistent"}, keys)
+	assert.Equal(t, []int{9, 11, 14, 17, 0}, ticks)
+
+	// Stop iteration
+	keys = nil
+	lru.RangeByExpiry(func(data lrumap.LruData, expireAt uint64) bool {
+		keys = append(keys, string(*data.Key()))
+		return len(keys) < 2
+	})
+	assert.Equal(t, []string{"k1", "k3"}, keys)
+}
+
+func TestWithJitter(t *testing.T) {
+	var seq uint64
+	lru := lrumap.New(12, lrumap.WithJitter(3), lrumap.WithJitterSource(func(n uint64) uint64 {
+		assert.Equal(t, uint64(4), n)
+		seq++
+		return seq % n
+	}))
+
+	for i := 0; i < 8; i++ {
+		assert.Nil(t, lru.Put(&testData{data: []byte(fmt.Sprintf("k%d", i))}, 5))
+	}
+	assert.Nil(t, lru.Put(&testData{data: []byte("persistent")}, 0))
+	assert.Equal(t, []int{0, 0, 0, 0, 0, 2, 2, 2, 2, 0, 0, 0, 0}, lru.FrameOccupancy())
+
+	// Capped at maxTick
+	assert.Nil(t, lru.Put(&testData{data: []byte("max")}, 11))
+	assert.Equal(t, 1, lru.CountExpiringAt(12))
+
+	assert.Equal(t, 8, lru.PruneCount(9))
+	assert.Equal(t, 2, lru.Size())
+}
+
+func TestWithJitterCoveredMethods(t *testing.T) {
+	maxSource := lrumap.WithJitterSource(func(n uint64) uint64 { return n - 1 })
+	lru := lrumap.New(20, lrumap.WithJitter(5), maxSource)
+
+	// PutAt expires exactly at the given tick
+	keyA := []byte("a")
+	assert.Nil(t, lru.PutAt(&testData{data: keyA}, 10))
+	expireAt, _ := lru.PeekExpiry(&keyA)
+	assert.Equal(t, 10, int(expireAt))
+
+	keyB := []byte("b")
+	stored, ok := lru.GetOrPut(&testData{data: keyB}, 10)
+	assert.NotNil(t, stored)
+	assert.True(t, ok)
+	expireAt, _ = lru.PeekExpiry(&keyB)
+	assert.Equal(t, 15, int(expireAt))
+
+	keyC := []byte("c")
+	assert.Nil(t, lru.PutRaw(keyC, 1, 10))
+	expireAt, _ = lru.PeekExpiry(&keyC)
+	assert.Equal(t, 15, int(expireAt))
+}
+
+func TestWithJitterDefaultSource(t *testing.T) {
+	lru := lrumap.New(100, lrumap.WithJitter(50))
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, lru.Put(&testData{data: []byte(fmt.Sprintf("k%d", i))}, 10))
+	}
+
+	frames := 0
+	for i, n := range lru.FrameOccupancy() {
+		if n > 0 {
+			assert.True(t, i >= 10 && i <= 60)
+			frames++
+		}
+	}
+	assert.True(t, frames > 1)
+}
+
+func TestWithJitterLargeMax(t *testing.T) {
+	lru := lrumap.New(10, lrumap.WithJitter(1<<64-1))
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, lru.Put(&testData{data: []byte(fmt.Sprintf("k%d", i))}, 5))
+	}
+	for i, n := range lru.FrameOccupancy() {
+		if n > 0 {
+			assert.True(t, i >= 5 && i <= 10)
+		}
+	}
+}
+
+func TestWithOnEvictReason(t *testing.T) {
+	reasons := map[string]lrumap.EvictReason{}
+	var evicted []string
+	lru := lrumap.New(12,
+		lrumap.WithMaxEntries(2),
+		lrumap.WithOnEvict(func(data lrumap.LruData) {
+			evicted = append(evicted, string(*data.Key()))
+		}),
+		lrumap.WithOnEvictReason(func(data lrumap.LruData, reason lrumap.EvictReason) {
+			reasons[string(*data.Key())] = reason
+		}))
+
+	keyA, keyB, keyC := []byte("a"), []byte("b"), []byte("c")
+	assert.Nil(t, lru.Put(&testData{data: keyA}, 2))
+	assert.Nil(t, lru.Put(&testData{data: keyB}, 5))
+	assert.Nil(t, lru.Put(&testData{data: keyC}, 5))
+	assert.NotNil(t, lru.Delete(&keyB))
+	assert.Equal(t, 0, len(*lru.Prune(5)))
+	assert.Equal(t, 1, len(*lru.Prune(1)))
+
+	assert.Equal(t, map[string]lrumap.EvictReason{
+		"a": lrumap.CapacityReason,
+		"b": lrumap.DeleteReason,
+		"c": lrumap.ExpiryReason,
+	}, reasons)
+
+	// Callback of WithOnEvict is not called for Delete
+	assert.Equal(t, []string{"a", "c"}, evicted)
+}
+
+func TestResize(t *testing.T) {
+	lru := lrumap.New(10, lrumap.WithSlidingExpiration())
+	lru.Prune(7)
+	keyA, keyB, keyC := []byte("a"), []byte("b"), []byte("c")
+	assert.Nil(t, lru.Put(&testData{data: keyA}, 3))
+	assert.Nil(t, lru.Put(&testData{data: keyB}, 10))
+	assert.Nil(t, lru.Put(&testData{data: keyC}, 0))
+
+	// Rejected because "b" expires after 10 ticks
+	assert.True(t, errors.Is(lru.Resize(5), lrumap.ErrTTLTooLarge))
+	assert.Equal(t, 10, int(lru.MaxTick()))
+	assert.Equal(t, 3, lru.Size())
+
+	// Grow
+	assert.Nil(t, lru.Resize(100))
+	assert.Equal(t, 100, int(lru.MaxTick()))
+	keyD := []byte("d")
+	assert.Nil(t, lru.Put(&testData{data: keyD}, 50))
+	assert.Equal(t, 101, len(lru.FrameOccupancy()))
+	assert.Equal(t, 1, lru.CountExpiringAt(10))
+	assert.Equal(t, 1, lru.CountExpiringAt(17))
+	assert.Equal(t, 1, lru.CountExpiringAt(57))
+
+	// Shrink after "d" is removed
+	assert.NotNil(t, lru.Delete(&keyD))
+	assert.Equal(t, 1, len(*lru.Prune(4)))
+	assert.Nil(t, lru.Resize(6))
+	assert.Equal(t, 6, int(lru.MaxTick()))
+	assert.Equal(t, lrumap.ErrTTLTooLarge, lru.Put(&testData{data: []byte("e")}, 7))
+
+	ttl, _ := lru.RemainingTTL(&keyB)
+	assert.Equal(t, 6, int(ttl))
+
+	// Sliding expiration uses TTL clamped to new maxTick
+	assert.NotNil(t, lru.Get(&keyB))
+	ttl, _ = lru.RemainingTTL(&keyB)
+	assert.Equal(t, 6, int(ttl))
+
+	assert.Equal(t, 0, len(*lru.Prune(6)))
+	assert.Equal(t, 1, len(*lru.Prune(1)))
+	assert.True(t, lru.Contains(&keyC))
+	assert.Equal(t, 1, lru.Size())
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	missing := []byte("xyz")
+	data1 := testData{data: key}
+	data2 := testData{data: key}
+	data3 := testData{data: key}
+	assert.Nil(t, lru.Put(&data1, 2))
+
+	// Absent key
+	assert.False(t, lru.CompareAndSwap(&missing, &data1, &testData{data: missing}, 2))
+	assert.False(t, lru.Contains(&missing))
+
+	// Mismatch
+	assert.False(t, lru.CompareAndSwap(&key, &data2, &data3, 2))
+	assert.True(t, &data1 == lru.Get(&key))
+
+	// Swapped with TTL reset
+	lru.Prune(1)
+	assert.True(t, lru.CompareAndSwap(&key, &data1, &data2, 5))
+	assert.True(t, &data2 == lru.Get(&key))
+	ttl, _ := lru.RemainingTTL(&key)
+	assert.Equal(t, 5, int(ttl))
+
+	// Key of new data object must be same
+	assert.False(t, lru.CompareAndSwap(&key, &data2, &testData{data: missing}, 5))
+
+	// Equality by function
+	assert.True(t, lru.CompareAndSwapFunc(&key, &data1, &data3, 5, func(current, expected lrumap.LruData) bool {
+		return bytes.Equal(*current.Key(), *expected.Key())
+	}))
+	assert.True(t, &data3 == lru.Get(&key))
+}
+
+func TestCompareAndSwapStoredKey(t *testing.T) {
+	lru := lrumap.New(12)
+	old := &testData{data: []byte("bar")}
+	assert.Nil(t, lru.PutString("foo", old, 2))
+	foo := []byte("foo")
+	assert.True(t, lru.CompareAndSwap(&foo, old, &testData{data: []byte("bar")}, 2))
+	assert.False(t, lru.CompareAndSwap(&foo, lru.GetString("foo"), &testData{data: []byte("foo")}, 2))
+
+	folded := lrumap.New(12, lrumap.WithKeyEqual(func(a, b *[]byte) bool {
+		return bytes.EqualFold(*a, *b)
+	}), lrumap.WithHasher(func(key *[]byte) uint64 { return uint64(len(*key)) }))
+	data := &testData{data: []byte("Foo")}
+	assert.Nil(t, folded.Put(data, 2))
+	assert.True(t, folded.CompareAndSwap(&foo, data, &testData{data: []byte("fOO")}, 2))
+}
+
+func TestUpdate(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	assert.Nil(t, lru.Put(&cloneableData{key: key, tags: []string{"blue"}}, 2))
+
+	assert.True(t, lru.Update(&key, func(d lrumap.LruData) lrumap.LruData {
+		return &cloneableData{key: key, tags: append(d.(*cloneableData).tags, "red")}
+	}))
+	assert.Equal(t, []string{"blue", "red"}, lru.Get(&key).(*cloneableData).tags)
+	assert.Equal(t, 1, lru.Size())
+
+	// Expiration is not changed
+	ttl, _ := lru.RemainingTTL(&key)
+	assert.Equal(t, 2, int(ttl))
+
+	missing := []byte("missing")
+	assert.False(t, lru.Update(&missing, func(d lrumap.LruData) lrumap.LruData {
+		t.Fatal("mutate is called for missing key")
+		return d
+	}))
+
+	// Changing key panics
+	assert.Panics(t, func() {
+		lru.Update(&key, func(lrumap.LruData) lrumap.LruData {
+			return &testData{data: []byte("xyz")}
+		})
+	})
+	assert.Equal(t, []string{"blue", "red"}, lru.Get(&key).(*cloneableData).tags)
+}
+
+func TestUpdateStoredKey(t *testing.T) {
+	identity := func(d lrumap.LruData) lrumap.LruData { return d }
+	lru := lrumap.New(12)
+
+	// Stored key differs from Key() of the data object
+	assert.Nil(t, lru.PutString("foo", &testData{data: []byte("bar")}, 2))
+	foo := []byte("foo")
+	assert.True(t, lru.Update(&foo, identity))
+	assert.Panics(t, func() {
+		lru.Update(&foo, func(lrumap.LruData) lrumap.LruData {
+			return &testData{data: []byte("foo")}
+		})
+	})
+
+	ns := lru.Namespace([]byte("ns"))
+	key := []byte("a")
+	assert.Nil(t, ns.Put(&testData{data: key}, 2))
+	assert.True(t, ns.Update(&key, func(lrumap.LruData) lrumap.LruData {
+		return &testData{data: []byte("a")}
+	}))
+	assert.NotNil(t, ns.Get(&key))
+
+	// Keys are compared as WithKeyEqual
+	folded := lrumap.New(12, lrumap.WithKeyEqual(func(a, b *[]byte) bool {
+		return bytes.EqualFold(*a, *b)
+	}), lrumap.WithHasher(func(key *[]byte) uint64 { return uint64(len(*key)) }))
+	assert.Nil(t, folded.Put(&testData{data: []byte("Foo")}, 2))
+	assert.True(t, folded.Update(&foo, func(lrumap.LruData) lrumap.LruData {
+		return &testData{data: []byte("fOO")}
+	}))
+}
+
+func TestPutWithIdle(t *testing.T) {
+	lru := lrumap.New(20)
+	keyA, keyB, keyC := []byte("a"), []byte("b"), []byte("c")
+	assert.Nil(t, lru.PutWithIdle(&testData{data: keyA}, 10, 3))
+	assert.Nil(t, lru.PutWithIdle(&testData{data: keyB}, 10, 3))
+	assert.Nil(t, lru.PutWithIdle(&testData{data: keyC}, 0, 2))
+	assert.Equal(t, lrumap.ErrTTLTooLarge, lru.PutWithIdle(&testData{data: []byte("d")}, 10, 21))
+	assert.Equal(t, lrumap.ErrDuplicateKey, lru.PutWithIdle(&testData{data: keyA}, 10, 3))
+
+	// Access extends life by idle TTL
+	for i := 0; i < 4; i++ {
+		lru.Prune(2)
+		assert.NotNil(t, lru.Get(&keyA))
+		assert.NotNil(t, lru.Get(&keyC))
+	}
+	ttl, _ := lru.RemainingTTL(&keyA)
+	assert.Equal(t, 2, int(ttl))
+
+	// "b" is not accessed
+	assert.False(t, lru.Contains(&keyB))
+
+	// Access does not extend life beyond hard TTL
+	assert.Equal(t, 0, len(*lru.Prune(2)))
+	assert.NotNil(t, lru.Get(&keyA))
+	assert.NotNil(t, lru.Get(&keyC))
+	pruned := lru.Prune(1)
+	assert.Equal(t, 1, len(*pruned))
+	assert.Equal(t, keyA, *(*pruned)[0].Key())
+
+	// No hard TTL
+	assert.NotNil(t, lru.Get(&keyC))
+	assert.Equal(t, 0, len(*lru.Prune(2)))
+	assert.Equal(t, 1, len(*lru.Prune(1)))
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestTouch(t *testing.T) {
+	lru := lrumap.New(12)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+	missing := []byte("123")
+	assert.Nil(t, lru.Put(&testData{data: key1}, 3))
+	assert.Nil(t, lru.Put(&testData{data: key2}, 3))
+	assert.False(t, lru.Touch(&missing))
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, 0, len(*lru.Prune(2)))
+		assert.True(t, lru.Touch(&key1))
+		assert.True(t, lru.Touch(&key2))
+	}
+
+	ttl, _ := lru.RemainingTTL(&key1)
+	assert.Equal(t, 3, int(ttl))
+
+	// Stop touching key1
+	assert.Equal(t, 0, len(*lru.Prune(2)))
+	assert.True(t, lru.Touch(&key2))
+	pruned := lru.Prune(2)
+	assert.Equal(t, 1, len(*pruned))
+	assert.Equal(t, key1, *(*pruned)[0].Key())
+	assert.False(t, lru.Touch(&key1))
+	assert.Equal(t, 1, lru.CountExpiringAt(lru.CurrentTick()+1))
+}
+
+type weightedData struct {
+	key    []byte
+	weight int
+}
+
+func (x *weightedData) Key() *[]byte { return &x.key }
+func (x *weightedData) Weight() int  { return x.weight }
+
+func TestWithMaxWeight(t *testing.T) {
+	var evicted []string
+	lru := lrumap.New(12, lrumap.WithMaxWeight(10), lrumap.WithOnEvictReason(func(data lrumap.LruData, reason lrumap.EvictReason) {
+		if reason == lrumap.CapacityReason {
+			evicted = append(evicted, string(*data.Key()))
+		}
+	}))
+
+	assert.Nil(t, lru.Put(&weightedData{key: []byte("heavy"), weight: 6}, 8))
+	assert.Nil(t, lru.Put(&weightedData{key: []byte("light"), weight: 2}, 3))
+	assert.Nil(t, lru.Put(&testData{data: []byte("plain")}, 5))
+	assert.Nil(t, lru.Put(&testData{data: []byte("persistent")}, 0))
+	assert.Equal(t, 10, lru.TotalWeight())
+	assert.Empty(t, evicted)
+
+	// Nearest to expiration first
+	assert.Nil(t, lru.Put(&weightedData{key: []byte("medium"), weight: 3}, 10))
+	assert.Equal(t, []string{"light", "plain"}, evicted)
+	assert.Equal(t, 10, lru.TotalWeight())
+	assert.Equal(t, 3, lru.Size())
+
+	// Replacing data object updates weight
+	assert.Nil(t, lru.Upsert(&weightedData{key: []byte("heavy"), weight: 1}, 8))
+	assert.Equal(t, 5, lru.TotalWeight())
+	assert.Equal(t, 1, lru.PruneCount(9))
+	assert.Equal(t, 4, lru.TotalWeight())
+
+	assert.NotNil(t, lru.DeleteString("persistent"))
+	assert.Equal(t, 3, lru.TotalWeight())
+	assert.Equal(t, uint64(2), lru.Stats().Evictions)
+}
+
+func TestWithMaxWeightKeepsNewData(t *testing.T) {
+	lru := lrumap.New(12, lrumap.WithMaxWeight(1))
+	keyA, keyB := []byte("a"), []byte("b")
+	assert.Nil(t, lru.Put(&testData{data: keyA}, 1))
+	assert.Nil(t, lru.Put(&testData{data: keyB}, 1))
+	assert.False(t, lru.Contains(&keyA))
+	assert.True(t, lru.Contains(&keyB))
+
+	// Data object heavier than the limit is rejected
+	heavy := []byte("heavy")
+	assert.Equal(t, lrumap.ErrTooHeavy, lru.Put(&weightedData{key: heavy, weight: 2}, 1))
+	assert.Equal(t, lrumap.ErrTooHeavy, lru.Upsert(&weightedData{key: keyB, weight: 2}, 1))
+	assert.False(t, lru.Contains(&heavy))
+	assert.True(t, lru.Contains(&keyB))
+	assert.Equal(t, 1, lru.TotalWeight())
+
+	// Other paths also refuse to store data object over the limit
+	stored, ok := lru.GetOrPut(&weightedData{key: heavy, weight: 5}, 1)
+	assert.Nil(t, stored)
+	assert.False(t, ok)
+	assert.False(t, lru.Contains(&heavy))
+
+	current := lru.Get(&keyB)
+	tooHeavy := &weightedData{key: keyB, weight: 9}
+	assert.False(t, lru.CompareAndSwap(&keyB, current, tooHeavy, 1))
+	assert.False(t, lru.Update(&keyB, func(lrumap.LruData) lrumap.LruData { return tooHeavy }))
+	assert.True(t, current == lru.Get(&keyB))
+
+	src := lrumap.New(12)
+	assert.Nil(t, src.Put(&testData{data: keyB}, 1))
+	assert.Equal(t, lrumap.ErrTooHeavy, lru.Merge(src, func(existing, incoming lrumap.LruData) lrumap.LruData {
+		return tooHeavy
+	}))
+	assert.True(t, current == lru.Get(&keyB))
+	assert.Equal(t, 1, lru.TotalWeight())
+	assert.Equal(t, 1, lru.Size())
+}
+
+func TestPruneFilter(t *testing.T) {
+	var evicted []string
+	lru := lrumap.New(12, lrumap.WithOnEvict(func(data lrumap.LruData) {
+		evicted = append(evicted, string(*data.Key()))
+	}))
+	pinned := []byte("pinned")
+	assert.Nil(t, lru.Put(&testData{data: pinned}, 2))
+	assert.Nil(t, lru.Put(&testData{data: []byte("a")}, 1))
+	assert.Nil(t, lru.Put(&testData{data: []byte("b")}, 2))
+	assert.Nil(t, lru.Put(&testData{data: []byte("c")}, 8))
+
+	keep := func(data lrumap.LruData) bool {
+		return bytes.Equal(*data.Key(), pinned)
+	}
+	pruned := lru.PruneFilter(3, keep)
+	assert.Equal(t, 2, len(*pruned))
+	assert.ElementsMatch(t, []string{"a", "b"}, evicted)
+	assert.Equal(t, 2, lru.Size())
+
+	// Pinned data object is rescheduled with its original TTL
+	ttl, _ := lru.RemainingTTL(&pinned)
+	assert.Equal(t, 2, int(ttl))
+	assert.Equal(t, 0, len(*lru.PruneFilter(3, keep)))
+	assert.True(t, lru.Contains(&pinned))
+
+	// Without pinning
+	assert.Equal(t, 2, len(*lru.PruneFilter(4, func(lrumap.LruData) bool { return false })))
+	assert.False(t, lru.Contains(&pinned))
+	assert.Equal(t, 0, lru.Size())
+}
+
+type cloneableData struct {
+	key  []byte
+	tags []string
+}
+
+func (x *cloneableData) Key() *[]byte {
+	return &x.key
+}
+
+func (x *cloneableData) Clone() lrumap.LruData {
+	return &cloneableData{
+		key:  x.key,
+		tags: append([]string(nil), x.tags...),
+	}
+}
+
+func TestGetClone(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	assert.Nil(t, lru.Put(&cloneableData{key: key, tags: []string{"blue"}}, 2))
+
+	clone := lru.GetClone(&key).(*cloneableData)
+	clone.tags[0] = "red"
+	clone.tags = append(clone.tags, "green")
+	assert.Equal(t, []string{"blue"}, lru.Get(&key).(*cloneableData).tags)
+
+	// Data object without Clone is returned as it is
+	other := &testData{data: []byte("other")}
+	assert.Nil(t, lru.Put(other, 2))
+	assert.True(t, lru.GetClone(&other.data) == lrumap.LruData(other))
+
+	missing := []byte("missing")
+	assert.Nil(t, lru.GetClone(&missing))
+}
+
+func TestLookup(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	missing := []byte("xyz")
+	var nilData *testData
+	assert.Nil(t, lru.PutString("abc", nilData, 2))
+
+	data, ok := lru.Lookup(&key)
+	assert.True(t, ok)
+	assert.True(t, data == nilData)
+
+	data, ok = lru.Lookup(&missing)
+	assert.False(t, ok)
+	assert.Nil(t, data)
+
+	sync := lrumap.NewSync(12)
+	assert.Nil(t, sync.Put(&testData{data: key}, 2))
+	_, ok = sync.Lookup(&key)
+	assert.True(t, ok)
+	_, ok = sync.Lookup(&missing)
+	assert.False(t, ok)
+}
+
+func TestHashKey(t *testing.T) {
+	key1 := []byte("abc")
+	key2 := []byte("abc")
+	key3 := []byte("xyz")
+	assert.Equal(t, lrumap.HashKey(&key1), lrumap.HashKey(&key2))
+	assert.NotEqual(t, lrumap.HashKey(&key1), lrumap.HashKey(&key3))
+
+	h := fnv.New64a()
+	h.Write(key1)
+	assert.Equal(t, h.Sum64(), lrumap.HashKey(&key1))
+
+	assert.Equal(t, lrumap.HashKey(&key1), lrumap.New(12).HashKey(&key1))
+	custom := lrumap.New(12, lrumap.WithHasher(func(key *[]byte) uint64 {
+		return uint64(len(*key))
+	}))
+	assert.Equal(t, uint64(3), custom.HashKey(&key1))
+}
+
+func TestWithMaxChainLength(t *testing.T) {
+	lru := lrumap.New(12, lrumap.WithMaxChainLength(2), lrumap.WithHasher(func(key *[]byte) uint64 {
+		return uint64((*key)[0])
+	}))
+
+	assert.Nil(t, lru.Put(&testData{data: []byte("x1")}, 2))
+	assert.Nil(t, lru.Put(&testData{data: []byte("x2")}, 2))
+	assert.Equal(t, lrumap.ErrChainTooLong, lru.Put(&testData{data: []byte("x3")}, 2))
+	assert.Equal(t, lrumap.ErrDuplicateKey, lru.Put(&testData{data: []byte("x2")}, 2))
+	assert.Nil(t, lru.Put(&testData{data: []byte("y1")}, 2))
+
+	// GetOrPut loads existing key but does not insert new key
+	data, ok := lru.GetOrPut(&testData{data: []byte("x1")}, 2)
+	assert.False(t, ok)
+	assert.NotNil(t, data)
+	data, ok = lru.GetOrPut(&testData{data: []byte("x3")}, 2)
+	assert.False(t, ok)
+	assert.Nil(t, data)
+	assert.Equal(t, 3, lru.Size())
+
+	key := []byte("x2")
+	assert.NotNil(t, lru.Delete(&key))
+	assert.Nil(t, lru.Put(&testData{data: []byte("x3")}, 2))
+}
+
+func TestDrain(t *testing.T) {
+	lru := lrumap.New(12)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, lru.Put(&testData{data: []byte(fmt.Sprintf("k%d", i))}, 3))
+	}
+	assert.Nil(t, lru.Put(&testData{data: []byte("persistent")}, 0))
+	lru.Prune(1)
+
+	size := lru.Size()
+	drained := lru.Drain()
+	assert.Equal(t, size, len(drained))
+
+	var keys []string
+	for _, d := range drained {
+		keys = append(keys, string(*d.Key()))
+	}
+	assert.Contains(t, keys, "k0")
+	assert.Contains(t, keys, "k9")
+	assert.Contains(t, keys, "persistent")
+
+	assert.True(t, lru.Empty())
+	assert.Equal(t, 0, len(*lru.Prune(12)))
+	assert.Empty(t, lru.Drain())
+	assert.Equal(t, 13, int(lru.CurrentTick()))
+}
+
+func TestWithKeyEqual(t *testing.T) {
+	lru := lrumap.New(12,
+		lrumap.WithKeyEqual(func(a, b *[]byte) bool {
+			return bytes.EqualFold(*a, *b)
+		}),
+		lrumap.WithHasher(func(key *[]byte) uint64 {
+			lower := bytes.ToLower(*key)
+			return lrumap.HashKey(&lower)
+		}))
+
+	data := testData{data: []byte("Hello")}
+	assert.Nil(t, lru.Put(&data, 2))
+	assert.Equal(t, lrumap.ErrDuplicateKey, lru.Put(&testData{data: []byte("HELLO")}, 2))
+
+	key := []byte("hello")
+	assert.True(t, &data == lru.Get(&key))
+	other := []byte("world")
+	assert.Nil(t, lru.Get(&other))
+
+	// Clone keeps key comparison
+	clone := lru.Clone()
+	assert.True(t, &data == clone.Get(&key))
+	assert.Equal(t, lrumap.ErrDuplicateKey, clone.Put(&testData{data: []byte("hElLo")}, 2))
+
+	assert.True(t, &data == lru.Delete(&key))
+	assert.True(t, lru.Empty())
+}
+
+func TestIterator(t *testing.T) {
+	lru := lrumap.New(12, lrumap.WithHasher(func(key *[]byte) uint64 {
+		// Make chains to walk in buckets
+		return uint64(len(*key))
+	}))
+
+	_, ok := lru.Iterator().Next()
+	assert.False(t, ok)
+
+	var expected []string
+	for i := 0; i < 120; i++ {
+		key := fmt.Sprintf("k%d", i)
+		expected = append(expected, key)
+		assert.Nil(t, lru.Put(&testData{data: []byte(key)}, 2))
+	}
+
+	var keys []string
+	it := lru.Iterator()
+	for {
+		data, ok := it.Next()
+		if !ok {
+			break
+		}
+		keys = append(keys, string(*data.Key()))
+	}
+	assert.Equal(t, 120, len(keys))
+	assert.ElementsMatch(t, expected, keys)
+
+	_, ok = it.Next()
+	assert.False(t, ok)
+}
+
+func TestCountBy(t *testing.T) {
+	lru := lrumap.New(12)
+	assert.Empty(t, lru.CountBy(func(lrumap.LruData) string { return "" }))
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, lru.Put(&testData{data: []byte(fmt.Sprintf("blue:%d", i))}, 2))
+	}
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, lru.Put(&testData{data: []byte(fmt.Sprintf("orange:%d", i))}, 2))
+	}
+
+	counts := lru.CountBy(func(data lrumap.LruData) string {
+		return string(bytes.SplitN(*data.Key(), []byte(":"), 2)[0])
+	})
+	assert.Equal(t, map[string]int{"blue": 5, "orange": 3}, counts)
+}
+
+func TestWrapped(t *testing.T) {
+	lru := lrumap.New(4)
+	assert.False(t, lru.Wrapped())
+	assert.Equal(t, uint64(0), lru.Revolutions())
+
+	lru.Prune(4)
+	assert.False(t, lru.Wrapped())
+	assert.Equal(t, uint64(0), lru.Revolutions())
+
+	lru.Prune(1)
+	assert.True(t, lru.Wrapped())
+	assert.Equal(t, uint64(1), lru.Revolutions())
+
+	lru.Prune(12)
+	assert.Equal(t, uint64(3), lru.Revolutions())
+}
+
+func TestNewWithError(t *testing.T) {
+	lru, err := lrumap.NewWithError(0)
+	assert.Equal(t, lrumap.ErrInvalidMaxTick, err)
+	assert.Nil(t, lru)
+
+	lru, err = lrumap.NewWithError(12, lrumap.WithMaxEntries(1))
+	assert.NoError(t, err)
+	assert.Equal(t, 12, int(lru.MaxTick()))
+	assert.Nil(t, lru.Put(&testData{data: []byte("a")}, 12))
+	assert.Equal(t, lrumap.ErrInvalidMaxTick, lru.Resize(0))
+
+	// New keeps accepting maxTick 0 only for data objects without expiration
+	zero := lrumap.New(0)
+	assert.Equal(t, lrumap.ErrTTLTooLarge, zero.Put(&testData{data: []byte("a")}, 1))
+	assert.Nil(t, zero.Put(&testData{data: []byte("a")}, 0))
+	assert.Equal(t, 0, len(*zero.Prune(3)))
+}
+
+func TestPeekExpiry(t *testing.T) {
+	lru := lrumap.New(12)
+	lru.Prune(5)
+	key := []byte("abc")
+	persistent := []byte("persistent")
+	missing := []byte("xyz")
+	assert.Nil(t, lru.Put(&testData{data: key}, 7))
+	assert.Nil(t, lru.Put(&testData{data: persistent}, 0))
+
+	expireAt, ok := lru.PeekExpiry(&key)
+	assert.True(t, ok)
+	assert.Equal(t, 12, int(expireAt))
+
+	// Not changed by Get and Prune
+	lru.Get(&key)
+	lru.Prune(3)
+	expireAt, _ = lru.PeekExpiry(&key)
+	assert.Equal(t, 12, int(expireAt))
+
+	expireAt, ok = lru.PeekExpiry(&persistent)
+	assert.True(t, ok)
+	assert.Equal(t, 0, int(expireAt))
+	_, ok = lru.PeekExpiry(&missing)
+	assert.False(t, ok)
+}
+
+func TestDeleteKeys(t *testing.T) {
+	lru := lrumap.New(12)
+	key1, key2, key3 := []byte("a"), []byte("b"), []byte("c")
+	missing := []byte("x")
+	assert.Nil(t, lru.Put(&testData{data: key1}, 2))
+	assert.Nil(t, lru.Put(&testData{data: key2}, 2))
+	assert.Nil(t, lru.Put(&testData{data: key3}, 2))
+
+	assert.Equal(t, 2, lru.DeleteKeys([]*[]byte{&key1, &missing, &key3, &key1}))
+	assert.Equal(t, 1, lru.Size())
+	assert.True(t, lru.Contains(&key2))
+	assert.Equal(t, 0, lru.DeleteKeys(nil))
+}
+
+func TestWithClampTTL(t *testing.T) {
+	lru := lrumap.New(12, lrumap.WithClampTTL())
+	key := []byte("abc")
+	assert.Nil(t, lru.Put(&testData{data: key}, 17))
+	assert.Equal(t, 1, lru.CountExpiringAt(12))
+	ttl, _ := lru.RemainingTTL(&key)
+	assert.Equal(t, 12, int(ttl))
+
+	lru.Prune(3)
+	assert.True(t, lru.SetTTL(&key, 100))
+	assert.Equal(t, 1, lru.CountExpiringAt(15))
+
+	_, ok := lru.GetOrPut(&testData{data: []byte("xyz")}, 13)
+	assert.True(t, ok)
+	assert.Equal(t, 2, lru.CountExpiringAt(15))
+
+	// Rejected by default
+	assert.Equal(t, lrumap.ErrTTLTooLarge, lrumap.New(12).Put(&testData{data: key}, 17))
+}
+
+func TestWheelSnapshot(t *testing.T) {
+	lru := lrumap.New(3)
+	lru.Prune(2)
+	assert.Nil(t, lru.Put(&testData{data: []byte("a")}, 1))
+	assert.Nil(t, lru.Put(&testData{data: []byte("b")}, 3))
+	assert.Nil(t, lru.Put(&testData{data: []byte("c")}, 3))
+	assert.Nil(t, lru.Put(&testData{data: []byte("d")}, 0))
+
+	snapshot := lru.WheelSnapshot()
+	assert.Equal(t, 4, len(snapshot))
+	for i, f := range snapshot {
+		assert.Equal(t, 2+i, int(f.Tick))
+	}
+	assert.Empty(t, snapshot[0].Keys)
+	assert.Equal(t, [][]byte{[]byte("a")}, snapshot[1].Keys)
+	assert.Empty(t, snapshot[2].Keys)
+	assert.ElementsMatch(t, [][]byte{[]byte("b"), []byte("c")}, snapshot[3].Keys)
+
+	// Keys are copied
+	snapshot[1].Keys[0][0] = 'x'
+	key := []byte("a")
+	assert.True(t, lru.Contains(&key))
+	assert.Equal(t, [][]byte{[]byte("a")}, lru.WheelSnapshot()[1].Keys)
+}
+
+type sessionData struct {
+	id   []byte
+	user string
+}
+
+func (x *sessionData) Key() *[]byte { return &x.id }
+
+func TestWithSecondaryIndex(t *testing.T) {
+	lru := lrumap.New(12, lrumap.WithSecondaryIndex(func(data lrumap.LruData) string {
+		return data.(*sessionData).user
+	}))
+
+	assert.Nil(t, lru.Put(&sessionData{id: []byte("s1"), user: "blue"}, 2))
+	assert.Nil(t, lru.Put(&sessionData{id: []byte("s2"), user: "blue"}, 5))
+	assert.Nil(t, lru.Put(&sessionData{id: []byte("s3"), user: "blue"}, 5))
+	assert.Nil(t, lru.Put(&sessionData{id: []byte("s4"), user: "orange"}, 5))
+	assert.Nil(t, lru.Put(&sessionData{id: []byte("s5"), user: "orange"}, 5))
+
+	// Index follows Prune, Delete and replacement
+	assert.Equal(t, 1, len(*lru.Prune(3)))
+	key := []byte("s2")
+	assert.NotNil(t, lru.Delete(&key))
+	assert.Nil(t, lru.Upsert(&sessionData{id: []byte("s5"), user: "blue"}, 5))
+	clone := lru.Clone()
+
+	assert.Equal(t, 2, lru.DeleteBySecondary("blue"))
+	assert.Equal(t, 0, lru.DeleteBySecondary("blue"))
+	assert.Equal(t, 1, lru.Size())
+	key = []byte("s4")
+	assert.True(t, lru.Contains(&key))
+	assert.Equal(t, 1, lru.DeleteBySecondary("orange"))
+	assert.True(t, lru.Empty())
+
+	// Clone keeps its own index
+	assert.Equal(t, 3, clone.Size())
+	assert.Equal(t, 1, clone.DeleteBySecondary("orange"))
+	assert.Equal(t, 2, clone.DeleteBySecondary("blue"))
+
+	clone.Clear()
+	assert.Equal(t, 0, clone.DeleteBySecondary("blue"))
+	assert.Equal(t, 0, lrumap.New(12).DeleteBySecondary("blue"))
+}
+
+func TestPruneZero(t *testing.T) {
+	var evicted int
+	lru := lrumap.New(12, lrumap.WithOnEvict(func(lrumap.LruData) { evicted++ }))
+	lru.Prune(3)
+	assert.Nil(t, lru.Put(&testData{data: []byte("a")}, 1))
+
+	pruned := lru.Prune(0)
+	assert.NotNil(t, pruned)
+	assert.Equal(t, 0, len(*pruned))
+	assert.Equal(t, 3, int(lru.CurrentTick()))
+	assert.Equal(t, 1, lru.Size())
+	assert.Equal(t, 0, evicted)
+
+	sync := lrumap.NewSync(12)
+	assert.Nil(t, sync.Put(&testData{data: []byte("a")}, 1))
+	assert.Equal(t, 0, len(*sync.Prune(0)))
+	assert.Equal(t, 1, sync.Size())
+
+	assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() { lru.Prune(0) }))
+	assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() { sync.Prune(0) }))
+}
+
+type largeData struct {
+	key     []byte
+	payload []byte
+}
+
+func (x *largeData) Key() *[]byte { return &x.key }
+
+// putCollectable puts largeData and returns channel closed when the data
+// object is collected.
+func putCollectable(t *testing.T, lru *lrumap.LruMap, key string) <-chan struct{} {
+	collected := make(chan struct{})
+	data := &largeData{key: []byte(key), payload: make([]byte, 1<<20)}
+	runtime.SetFinalizer(data, func(*largeData) { close(collected) })
+	assert.Nil(t, lru.Put(data, 1))
+	return collected
+}
+
+func waitCollected(t *testing.T, collected <-chan struct{}) {
+	for i := 0; i < 20; i++ {
+		runtime.GC()
+		select {
+		case <-collected:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Error("Removed data object is not collected")
+}
+
+func TestRemovedDataCollectable(t *testing.T) {
+	lru := lrumap.New(12)
+	deleted := putCollectable(t, lru, "deleted")
+	cleared := putCollectable(t, lru, "cleared")
+	key := []byte("deleted")
+	assert.NotNil(t, lru.Delete(&key))
+	lru.Clear()
+
+	pruned := putCollectable(t, lru, "pruned")
+	assert.Equal(t, 1, lru.PruneCount(2))
+
+	waitCollected(t, pruned)
+	waitCollected(t, deleted)
+	waitCollected(t, cleared)
+	runtime.KeepAlive(lru)
+}
+
+func TestPruneLimited(t *testing.T) {
+	var evicted int
+	lru := lrumap.New(12, lrumap.WithOnEvict(func(lrumap.LruData) { evicted++ }))
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, lru.Put(&testData{data: []byte(fmt.Sprintf("a%d", i))}, 1))
+	}
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, lru.Put(&testData{data: []byte(fmt.Sprintf("b%d", i))}, 3))
+	}
+	assert.Nil(t, lru.Put(&testData{data: []byte("c")}, 8))
+
+	// Stop in the frame of tick 1
+	pruned, more := lru.PruneLimited(5, 3)
+	assert.Equal(t, 3, len(*pruned))
+	assert.True(t, more)
+	assert.Equal(t, 1, int(lru.CurrentTick()))
+
+	// Rest of progress
+	pruned, more = lru.PruneLimited(4, 3)
+	assert.Equal(t, 3, len(*pruned))
+	assert.True(t, more)
+	assert.Equal(t, 3, int(lru.CurrentTick()))
+
+	pruned, more = lru.PruneLimited(2, 3)
+	assert.Equal(t, 2, len(*pruned))
+	assert.False(t, more)
+	assert.Equal(t, 5, int(lru.CurrentTick()))
+
+	assert.Equal(t, 8, evicted)
+	assert.Equal(t, 1, lru.Size())
+	assert.Equal(t, uint64(8), lru.Stats().Prunes)
+
+	// Progress over maxTick
+	pruned, more = lru.PruneLimited(100, 3)
+	assert.Equal(t, 1, len(*pruned))
+	assert.False(t, more)
+	assert.Equal(t, 105, int(lru.CurrentTick()))
+}
+
+func TestEvictChannel(t *testing.T) {
+	lru := lrumap.New(12)
+	ch := lru.EvictChannel(4)
+	assert.Nil(t, lru.Put(&testData{data: []byte("a")}, 1))
+	assert.Nil(t, lru.Put(&testData{data: []byte("b")}, 2))
+	assert.Nil(t, lru.Put(&testData{data: []byte("c")}, 3))
+	assert.Nil(t, lru.Put(&testData{data: []byte("d")}, 3))
+
+	// Delete is not sent
+	key := []byte("d")
+	assert.NotNil(t, lru.Delete(&key))
+
+	lru.Prune(2)
+	lru.PruneCount(1)
+	lru.Prune(1)
+	lru.CloseEvictChannel()
+
+	var keys []string
+	for data := range ch {
+		keys = append(keys, string(*data.Key()))
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+}
+
+func TestEvictChannelDrop(t *testing.T) {
+	lru := lrumap.New(12)
+	ch := lru.EvictChannel(2)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, lru.Put(&testData{data: []byte(fmt.Sprintf("k%d", i))}, 1))
+	}
+
+	// Pruning is not blocked by the full channel
+	assert.Equal(t, 5, len(*lru.Prune(2)))
+	assert.Equal(t, 2, len(ch))
+
+	// Previous channel is closed by EvictChannel
+	next := lru.EvictChannel(1)
+	<-ch
+	<-ch
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(next))
+}
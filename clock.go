@@ -0,0 +1,138 @@
+package lrumap
+
+import (
+	"errors"
+	"time"
+)
+
+// Clock is an interface to get current time for LruMap created by
+// NewWithClock. It can be replaced with WithClock for testing, and
+// clocktest.FakeClock is available for the purpose.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (x systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (x systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// timerClock is implemented by Clock having its own timer, such as
+// clocktest.FakeClock. Timers of other Clock are based on time.After.
+type timerClock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+// after returns channel that receives time after d elapsed on the clock.
+func after(clock Clock, d time.Duration) <-chan time.Time {
+	if tc, ok := clock.(timerClock); ok {
+		return tc.After(d)
+	}
+	return time.After(d)
+}
+
+// WithClock replaces clock of LruMap. Default clock is based on time.Now.
+func WithClock(clock Clock) Option {
+	return func(x *LruMap) {
+		x.clock = clock
+	}
+}
+
+// NewWithClock is a constructor of LruMap with time.Duration based TTL.
+// Wall clock time is converted to tick by resolution, and maxTTL is
+// converted to maxTick of the map. Current tick of the map is advanced
+// automatically by elapsed time, and expired data objects are pruned in
+// PutDuration and Get.
+func NewWithClock(resolution, maxTTL time.Duration, options ...Option) *LruMap {
+	if resolution <= 0 {
+		resolution = time.Second
+	}
+
+	lruMap := New(durationToTick(maxTTL, resolution), options...)
+	if lruMap.clock == nil {
+		lruMap.clock = systemClock{}
+	}
+	lruMap.resolution = resolution
+	lruMap.origin = lruMap.clock.Now()
+	return lruMap
+}
+
+// PutDuration inserts data object with time.Duration based TTL. The TTL is
+// rounded up to tick resolution, so data object never expires earlier than
+// the TTL. Zero or negative TTL means the data object never expires as ttl 0
+// of Put. PutDuration is only available for LruMap created by NewWithClock.
+func (x *LruMap) PutDuration(obj LruData, ttl time.Duration) error {
+	if x.resolution <= 0 {
+		return errors.New("PutDuration requires LruMap created by NewWithClock")
+	}
+
+	x.advance()
+	return x.Put(obj, durationToTick(ttl, x.resolution))
+}
+
+// PruneExpired advances current tick by elapsed time since the last prune
+// and returns pruned data objects, so that a developer does not need to
+// compute progress of Prune. It returns empty slice for LruMap not created
+// by NewWithClock.
+func (x *LruMap) PruneExpired() *[]LruData {
+	progress := x.expiredProgress()
+	if progress == 0 {
+		return &[]LruData{}
+	}
+	return x.Prune(progress)
+}
+
+// nextPruneAfter returns duration until PruneExpired prunes the next
+// expiring data object, or false if no data object will expire.
+func (x *LruMap) nextPruneAfter() (time.Duration, bool) {
+	if x.resolution <= 0 || x.Size() == 0 {
+		return 0, false
+	}
+	next := x.nextExpiring(nil)
+	if next == nil {
+		return 0, false
+	}
+
+	// The frame of expiration tick is pruned when the tick elapses
+	at := x.origin.Add(time.Duration(next.expireAt()+1) * x.resolution)
+	return at.Sub(x.clock.Now()), true
+}
+
+// expiredProgress returns number of ticks to catch up with elapsed time.
+func (x *LruMap) expiredProgress() tick {
+	if x.resolution <= 0 {
+		return 0
+	}
+
+	d := x.clock.Now().Sub(x.origin)
+	if d < 0 {
+		// Clock is moved backward before origin
+		return 0
+	}
+
+	elapsed := tick(d / x.resolution)
+	if elapsed <= x.current {
+		return 0
+	}
+	return elapsed - x.current
+}
+
+// advance prunes frames until current tick catches up with elapsed time.
+// It does nothing for LruMap not created by NewWithClock.
+func (x *LruMap) advance() {
+	if x.resolution > 0 {
+		x.PruneExpired()
+	}
+}
+
+func durationToTick(d, resolution time.Duration) tick {
+	if d <= 0 {
+		return 0
+	}
+	return tick((d + resolution - 1) / resolution)
+}
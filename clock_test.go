@@ -0,0 +1,99 @@
+package lrumap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/lrumap"
+	"github.com/m-mizutani/lrumap/clocktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutDuration(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	lru := lrumap.NewWithClock(time.Second, 10*time.Second, lrumap.WithClock(clock))
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+
+	// 1.5 seconds is rounded up to 2 ticks
+	assert.Nil(t, lru.PutDuration(&testData{data: key1}, 1500*time.Millisecond))
+	assert.NotNil(t, lru.PutDuration(&testData{data: key2}, 11*time.Second))
+
+	clock.Advance(2900 * time.Millisecond)
+	assert.NotNil(t, lru.Get(&key1))
+
+	clock.Advance(100 * time.Millisecond)
+	assert.Nil(t, lru.Get(&key1))
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestPutDurationNotEarly(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	lru := lrumap.NewWithClock(time.Second, 10*time.Second, lrumap.WithClock(clock))
+	key := []byte("abc")
+
+	// Put in the middle of a tick
+	clock.Advance(700 * time.Millisecond)
+	assert.Nil(t, lru.PutDuration(&testData{data: key}, time.Second))
+
+	clock.Advance(999 * time.Millisecond)
+	assert.NotNil(t, lru.Get(&key))
+
+	clock.Advance(2 * time.Second)
+	assert.Nil(t, lru.Get(&key))
+}
+
+func TestPutDurationWithoutClock(t *testing.T) {
+	lru := lrumap.New(12)
+	assert.NotNil(t, lru.PutDuration(&testData{data: []byte("abc")}, time.Second))
+}
+
+func TestPruneExpired(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	lru := lrumap.NewWithClock(100*time.Millisecond, time.Second, lrumap.WithClock(clock))
+	key1 := []byte("a")
+	key2 := []byte("b")
+	key3 := []byte("c")
+	assert.Nil(t, lru.PutDuration(&testData{data: key1}, 100*time.Millisecond))
+	assert.Nil(t, lru.PutDuration(&testData{data: key2}, 300*time.Millisecond))
+	assert.Nil(t, lru.PutDuration(&testData{data: key3}, time.Second))
+
+	// No time elapsed
+	assert.Equal(t, 0, len(*lru.PruneExpired()))
+
+	clock.Advance(250 * time.Millisecond)
+	pruned := lru.PruneExpired()
+	assert.Equal(t, 1, len(*pruned))
+	assert.Equal(t, key1, *(*pruned)[0].Key())
+	assert.Equal(t, 2, int(lru.CurrentTick()))
+
+	clock.Advance(200 * time.Millisecond)
+	pruned = lru.PruneExpired()
+	assert.Equal(t, 1, len(*pruned))
+	assert.Equal(t, key2, *(*pruned)[0].Key())
+	assert.Equal(t, 4, int(lru.CurrentTick()))
+
+	clock.Advance(time.Second)
+	assert.Equal(t, 1, len(*lru.PruneExpired()))
+	assert.Equal(t, 0, lru.Size())
+
+	// Tick based LruMap
+	assert.Equal(t, 0, len(*lrumap.New(12).PruneExpired()))
+}
+
+func TestFakeClockExpiry(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	lru := lrumap.NewWithClock(time.Minute, time.Hour, lrumap.WithClock(clock))
+	key := []byte("abc")
+	assert.Nil(t, lru.PutDuration(&testData{data: key}, 10*time.Minute))
+
+	// Clock skew to the past does not prune anything
+	clock.Advance(-time.Hour)
+	assert.Equal(t, 0, len(*lru.PruneExpired()))
+	assert.NotNil(t, lru.Get(&key))
+
+	clock.Advance(time.Hour + 10*time.Minute)
+	assert.NotNil(t, lru.Get(&key))
+	clock.Advance(time.Minute)
+	assert.Nil(t, lru.Get(&key))
+}
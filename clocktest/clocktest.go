@@ -0,0 +1,94 @@
+// Package clocktest provides a fake clock implementing lrumap.Clock for
+// deterministic tests of time based features.
+package clocktest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock that advances only on demand. It is safe for
+// concurrent use.
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+// NewFakeClock is a constructor of FakeClock starting at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns current time of the clock.
+func (x *FakeClock) Now() time.Time {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	return x.now
+}
+
+// Advance moves the clock forward by d. Negative d moves it backward to
+// simulate clock skew.
+func (x *FakeClock) Advance(d time.Duration) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.now = x.now.Add(d)
+	x.fire()
+}
+
+// Set changes current time of the clock to now.
+func (x *FakeClock) Set(now time.Time) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	x.now = now
+	x.fire()
+}
+
+// After returns channel that receives current time of the clock when the
+// clock is advanced by d or more, as time.After.
+func (x *FakeClock) After(d time.Duration) <-chan time.Time {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	ch := make(chan time.Time, 1)
+	x.timers = append(x.timers, fakeTimer{deadline: x.now.Add(d), ch: ch})
+	x.fire()
+	return ch
+}
+
+// NextTimer returns duration until the earliest timer created by After
+// fires. The bool value is false if no timer is waiting.
+func (x *FakeClock) NextTimer() (time.Duration, bool) {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+
+	if len(x.timers) == 0 {
+		return 0, false
+	}
+	next := x.timers[0].deadline
+	for _, t := range x.timers[1:] {
+		if t.deadline.Before(next) {
+			next = t.deadline
+		}
+	}
+	return next.Sub(x.now), true
+}
+
+// fire sends current time to timers reaching their deadline. It must be
+// called with lock.
+func (x *FakeClock) fire() {
+	waiting := x.timers[:0]
+	for _, t := range x.timers {
+		if t.deadline.After(x.now) {
+			waiting = append(waiting, t)
+			continue
+		}
+		t.ch <- x.now
+	}
+	x.timers = waiting
+}
@@ -0,0 +1,54 @@
+package clocktest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/lrumap/clocktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock(t *testing.T) {
+	base := time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)
+	clock := clocktest.NewFakeClock(base)
+	assert.Equal(t, base, clock.Now())
+
+	clock.Advance(3 * time.Second)
+	assert.Equal(t, base.Add(3*time.Second), clock.Now())
+
+	clock.Advance(-time.Second)
+	assert.Equal(t, base.Add(2*time.Second), clock.Now())
+
+	clock.Set(base)
+	assert.Equal(t, base, clock.Now())
+}
+
+func TestFakeClockAfter(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC))
+	_, ok := clock.NextTimer()
+	assert.False(t, ok)
+
+	ch1 := clock.After(3 * time.Second)
+	ch2 := clock.After(time.Second)
+	d, ok := clock.NextTimer()
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, d)
+
+	clock.Advance(time.Second)
+	assert.Equal(t, clock.Now(), <-ch2)
+	d, _ = clock.NextTimer()
+	assert.Equal(t, 2*time.Second, d)
+	select {
+	case <-ch1:
+		t.Fatal("timer fired before deadline")
+	default:
+	}
+
+	clock.Advance(5 * time.Second)
+	assert.Equal(t, clock.Now(), <-ch1)
+	_, ok = clock.NextTimer()
+	assert.False(t, ok)
+
+	// Timer without duration fires immediately
+	assert.Equal(t, clock.Now(), <-clock.After(0))
+}
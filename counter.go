@@ -0,0 +1,43 @@
+package lrumap
+
+// IntData is a data object holding int64 value for AddInt.
+type IntData struct {
+	key   []byte
+	Value int64
+}
+
+// Key returns key of the counter.
+func (x *IntData) Key() *[]byte {
+	return &x.key
+}
+
+// AddInt adds delta to IntData of the key and returns the new value. TTL of
+// the existing counter is not changed. If the key does not exist, a new
+// counter starting at delta is put with ttl. If data object of the key is
+// not IntData, it is replaced by the new counter and TTL is reset by ttl
+// without calling the callbacks. AddInt returns 0 and the error of Put, e.g.
+// ErrTTLTooLarge, if the new counter can not be stored.
+func (x *LruMap) AddInt(key *[]byte, delta int64, ttl tick) (int64, error) {
+	target := x.lookup(key)
+	if target != nil {
+		if counter, ok := target.data.(*IntData); ok {
+			counter.Value += delta
+			x.touch(target)
+			return counter.Value, nil
+		}
+	}
+
+	counter := &IntData{key: copyKey(*key), Value: delta}
+	if err := x.put(&counter.key, counter, ttl, true); err != nil {
+		return 0, err
+	}
+	return delta, nil
+}
+
+// AddInt adds delta to IntData of the key with holding write lock. See
+// LruMap.AddInt.
+func (x *SyncLruMap) AddInt(key *[]byte, delta int64, ttl tick) (int64, error) {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.AddInt(key, delta, ttl)
+}
@@ -0,0 +1,64 @@
+package lrumap_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/m-mizutani/lrumap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddInt(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("10.0.0.1")
+	stored := func(v int64, err error) int64 {
+		assert.NoError(t, err)
+		return v
+	}
+	assert.Equal(t, int64(1), stored(lru.AddInt(&key, 1, 3)))
+	assert.Equal(t, int64(3), stored(lru.AddInt(&key, 2, 10)))
+	assert.Equal(t, int64(-2), stored(lru.AddInt(&key, -5, 10)))
+	assert.Equal(t, int64(-2), lru.Get(&key).(*lrumap.IntData).Value)
+
+	// TTL is set by the first call
+	ttl, _ := lru.RemainingTTL(&key)
+	assert.Equal(t, 3, int(ttl))
+	assert.Equal(t, 1, len(*lru.Prune(4)))
+	assert.Equal(t, int64(4), stored(lru.AddInt(&key, 4, 10)))
+
+	// Data object that is not IntData is replaced
+	other := []byte("other")
+	assert.Nil(t, lru.Put(&testData{data: other}, 2))
+	assert.Equal(t, int64(7), stored(lru.AddInt(&other, 7, 5)))
+	assert.Equal(t, int64(7), lru.Get(&other).(*lrumap.IntData).Value)
+	assert.Equal(t, 2, lru.Size())
+
+	// Counter that can not be stored is reported
+	dropped := []byte("dropped")
+	v, err := lru.AddInt(&dropped, 1, 13)
+	assert.Equal(t, lrumap.ErrTTLTooLarge, err)
+	assert.Equal(t, int64(0), v)
+	assert.False(t, lru.Contains(&dropped))
+}
+
+func TestSyncAddInt(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	key := []byte("counter")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := lru.AddInt(&key, 1, 10)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	v, err := lru.AddInt(&key, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(801), v)
+}
@@ -0,0 +1,94 @@
+package lrumap
+
+import "fmt"
+
+// Map is a generic alternative of LruMap. Map stores value of V with key
+// of K directly, so a developer does not need to implement LruData.
+// Map works with the same tick based expiration as LruMap.
+type Map[K comparable, V any] struct {
+	lru    *LruMap
+	encode func(K) []byte
+}
+
+type mapEntry[K comparable, V any] struct {
+	key K
+	raw []byte
+	val V
+}
+
+func (x *mapEntry[K, V]) Key() *[]byte {
+	return &x.raw
+}
+
+// NewMap is a constructor of Map. encode converts key to byte slice that is
+// used to hash and compare keys in the table, then encode must return
+// distinct byte slices for distinct keys. If encode is nil, fmt based
+// encoding ("%#v") is used.
+func NewMap[K comparable, V any](maxTick tick, encode func(K) []byte, options ...Option) *Map[K, V] {
+	if encode == nil {
+		encode = func(key K) []byte {
+			return []byte(fmt.Sprintf("%#v", key))
+		}
+	}
+
+	return &Map[K, V]{
+		lru:    New(maxTick, options...),
+		encode: encode,
+	}
+}
+
+// Put inserts value with key into Map. Map does not allow to insert value
+// with duplicated key.
+func (x *Map[K, V]) Put(key K, val V, ttl tick) error {
+	entry := &mapEntry[K, V]{
+		key: key,
+		raw: x.encode(key),
+		val: val,
+	}
+	return x.lru.Put(entry, ttl)
+}
+
+// Get returns value of the key. The bool value is false if the key does not
+// exist.
+func (x *Map[K, V]) Get(key K) (V, bool) {
+	return x.entryValue(x.lru.Get(x.rawKey(key)), key)
+}
+
+// Delete removes value of the key from Map and returns it. The bool value is
+// false if the key does not exist.
+func (x *Map[K, V]) Delete(key K) (V, bool) {
+	return x.entryValue(x.lru.Delete(x.rawKey(key)), key)
+}
+
+// Prune is update current tick by adding `progress` and returns pruned values.
+func (x *Map[K, V]) Prune(progress tick) []V {
+	pruned := x.lru.Prune(progress)
+	res := make([]V, 0, len(*pruned))
+	for _, d := range *pruned {
+		res = append(res, d.(*mapEntry[K, V]).val)
+	}
+	return res
+}
+
+// Size returns number of values in Map.
+func (x *Map[K, V]) Size() int {
+	return x.lru.Size()
+}
+
+func (x *Map[K, V]) rawKey(key K) *[]byte {
+	raw := x.encode(key)
+	return &raw
+}
+
+func (x *Map[K, V]) entryValue(d LruData, key K) (V, bool) {
+	var zero V
+	if d == nil {
+		return zero, false
+	}
+
+	entry := d.(*mapEntry[K, V])
+	if entry.key != key {
+		return zero, false
+	}
+	return entry.val, true
+}
@@ -0,0 +1,61 @@
+package lrumap_test
+
+import (
+	"testing"
+
+	"github.com/m-mizutani/lrumap"
+	"github.com/stretchr/testify/assert"
+)
+
+type genericValue struct {
+	name string
+}
+
+func TestMapBasicScenario(t *testing.T) {
+	m := lrumap.NewMap[string, *genericValue](12, nil)
+
+	_, ok := m.Get("abc")
+	assert.False(t, ok)
+
+	v1 := &genericValue{name: "blue"}
+	assert.Nil(t, m.Put("abc", v1, 2))
+	assert.NotNil(t, m.Put("abc", v1, 2))
+	assert.Equal(t, 1, m.Size())
+
+	v, ok := m.Get("abc")
+	assert.True(t, ok)
+	assert.Equal(t, v1, v)
+	_, ok = m.Get("xyz")
+	assert.False(t, ok)
+
+	assert.Equal(t, 0, len(m.Prune(2)))
+	pruned := m.Prune(1)
+	assert.Equal(t, []*genericValue{v1}, pruned)
+	_, ok = m.Get("abc")
+	assert.False(t, ok)
+	assert.Equal(t, 0, m.Size())
+}
+
+func TestMapCustomEncoder(t *testing.T) {
+	type pair struct {
+		a, b int
+	}
+	encode := func(k pair) []byte {
+		return []byte{byte(k.a), byte(k.b)}
+	}
+	m := lrumap.NewMap[pair, int](12, encode)
+
+	assert.Nil(t, m.Put(pair{1, 2}, 12, 3))
+	assert.Nil(t, m.Put(pair{2, 1}, 21, 3))
+
+	v, ok := m.Get(pair{1, 2})
+	assert.True(t, ok)
+	assert.Equal(t, 12, v)
+
+	v, ok = m.Delete(pair{2, 1})
+	assert.True(t, ok)
+	assert.Equal(t, 21, v)
+	_, ok = m.Get(pair{2, 1})
+	assert.False(t, ok)
+	assert.Equal(t, 1, m.Size())
+}
@@ -0,0 +1,59 @@
+package lrumap
+
+import (
+	"encoding/gob"
+	"io"
+)
+
+type gobSnapshot struct {
+	MaxTick uint64
+	Current uint64
+	Entries []gobEntry
+}
+
+type gobEntry struct {
+	Key    []byte
+	Value  LruData
+	Remain uint64
+}
+
+// WriteGob writes all data objects in LruMap table with their remaining TTL
+// to w by encoding/gob. Concrete types of data objects must be registered by
+// gob.Register before calling WriteGob and ReadGob because LruData is an
+// interface.
+func (x *LruMap) WriteGob(w io.Writer) error {
+	snapshot := gobSnapshot{
+		MaxTick: uint64(x.maxTick),
+		Current: uint64(x.current),
+		Entries: make([]gobEntry, 0, x.Size()),
+	}
+	x.walk(func(n *node) bool {
+		snapshot.Entries = append(snapshot.Entries, gobEntry{
+			Key:    n.key,
+			Value:  n.data,
+			Remain: snapshotRemain(n, x.current),
+		})
+		return true
+	})
+
+	return gob.NewEncoder(w).Encode(&snapshot)
+}
+
+// ReadGob rebuilds LruMap from data written by WriteGob. Current tick and
+// maxTick are restored, then remaining TTL of each data object is preserved.
+func ReadGob(r io.Reader, options ...Option) (*LruMap, error) {
+	var snapshot gobSnapshot
+	if err := gob.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+
+	lru := New(tick(snapshot.MaxTick), options...)
+	lru.current = tick(snapshot.Current)
+	for _, entry := range snapshot.Entries {
+		if err := lru.restoreEntry(entry.Key, entry.Value, entry.Remain); err != nil {
+			return nil, err
+		}
+	}
+
+	return lru, nil
+}
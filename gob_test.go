@@ -0,0 +1,73 @@
+package lrumap_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/m-mizutani/lrumap"
+	"github.com/stretchr/testify/assert"
+)
+
+type gobData struct {
+	ID    []byte
+	Value string
+}
+
+func (x *gobData) Key() *[]byte {
+	return &x.ID
+}
+
+func init() {
+	gob.Register(&gobData{})
+}
+
+func TestGobRoundTrip(t *testing.T) {
+	lru := lrumap.New(12)
+	lru.Prune(3)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+	key3 := []byte("persistent")
+	assert.Nil(t, lru.Put(&gobData{ID: key1, Value: "blue"}, 2))
+	assert.Nil(t, lru.Put(&gobData{ID: key2, Value: "orange"}, 7))
+	assert.Nil(t, lru.Put(&gobData{ID: key3, Value: "red"}, 0))
+	lru.Prune(1)
+
+	var buf bytes.Buffer
+	assert.NoError(t, lru.WriteGob(&buf))
+
+	restored, err := lrumap.ReadGob(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, restored.Size())
+	assert.Equal(t, 4, int(restored.CurrentTick()))
+	assert.Equal(t, 12, int(restored.MaxTick()))
+	assert.Equal(t, "orange", restored.Get(&key2).(*gobData).Value)
+
+	ttl, ok := restored.RemainingTTL(&key1)
+	assert.True(t, ok)
+	assert.Equal(t, 1, int(ttl))
+	ttl, _ = restored.RemainingTTL(&key2)
+	assert.Equal(t, 6, int(ttl))
+
+	assert.Equal(t, 1, len(*restored.Prune(2)))
+	assert.Equal(t, 1, len(*restored.Prune(5)))
+	assert.Equal(t, "red", restored.Get(&key3).(*gobData).Value)
+}
+
+func TestReadGobInvalid(t *testing.T) {
+	_, err := lrumap.ReadGob(bytes.NewReader([]byte("broken")))
+	assert.Error(t, err)
+}
+
+func TestGobRoundTripStoredKey(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("a")
+	assert.Nil(t, lru.Namespace([]byte("ns")).Put(&gobData{ID: key, Value: "blue"}, 2))
+
+	var buf bytes.Buffer
+	assert.Nil(t, lru.WriteGob(&buf))
+
+	restored, err := lrumap.ReadGob(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "blue", restored.Namespace([]byte("ns")).Get(&key).(*gobData).Value)
+}
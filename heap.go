@@ -0,0 +1,124 @@
+package lrumap
+
+import "container/heap"
+
+// HeapLruMap is an alternative of LruMap that keeps data objects in a
+// min-heap ordered by expiration tick instead of the tick wheel. It has no
+// maxTick limit and does not allocate frames for ticks, but Put and Prune
+// take O(log n) time for each data object.
+type HeapLruMap struct {
+	table   map[string]*heapEntry
+	queue   heapQueue
+	current tick
+}
+
+type heapEntry struct {
+	// key is copy of the key at Put not to be affected by modification
+	// of the key after Put
+	key      string
+	data     LruData
+	expireAt tick
+	index    int
+}
+
+// NewHeap is a constructor of HeapLruMap.
+func NewHeap() *HeapLruMap {
+	return &HeapLruMap{
+		table: make(map[string]*heapEntry),
+	}
+}
+
+// Put inserts data object into HeapLruMap table. HeapLruMap does not allow
+// to insert object with duplicated key. Data object with ttl 0 never
+// expires and is not pruned by Prune.
+func (x *HeapLruMap) Put(obj LruData, ttl tick) error {
+	key := string(*obj.Key())
+	if _, ok := x.table[key]; ok {
+		return ErrDuplicateKey
+	}
+
+	entry := &heapEntry{key: key, data: obj, index: -1}
+	if ttl > 0 {
+		entry.expireAt = x.current + ttl
+		heap.Push(&x.queue, entry)
+	}
+	x.table[key] = entry
+	return nil
+}
+
+// Get returns data object if exists.
+func (x *HeapLruMap) Get(key *[]byte) LruData {
+	if entry, ok := x.table[string(*key)]; ok {
+		return entry.data
+	}
+	return nil
+}
+
+// Delete removes data object from HeapLruMap table and returns it. If the
+// key does not exist, Delete returns nil.
+func (x *HeapLruMap) Delete(key *[]byte) LruData {
+	entry, ok := x.table[string(*key)]
+	if !ok {
+		return nil
+	}
+
+	delete(x.table, string(*key))
+	if entry.index >= 0 {
+		heap.Remove(&x.queue, entry.index)
+	}
+	return entry.data
+}
+
+// Prune is update current tick by adding `progress` and returns pruned data
+// objects in order of expiration. Data objects are pruned at the same tick
+// as LruMap, then Put with ttl and Prune with the same progress remove the
+// data object at next Prune.
+func (x *HeapLruMap) Prune(progress tick) *[]LruData {
+	var pruned []LruData
+	limit := x.current + progress
+	for len(x.queue) > 0 && x.queue[0].expireAt < limit {
+		entry := heap.Pop(&x.queue).(*heapEntry)
+		delete(x.table, entry.key)
+		pruned = append(pruned, entry.data)
+	}
+
+	x.current = limit
+	return &pruned
+}
+
+// Size returns number of data object in the HeapLruMap table.
+func (x *HeapLruMap) Size() int {
+	return len(x.table)
+}
+
+// CurrentTick returns current tick of HeapLruMap that is advanced by Prune.
+func (x *HeapLruMap) CurrentTick() tick {
+	return x.current
+}
+
+// heapQueue implements heap.Interface ordered by expiration tick.
+type heapQueue []*heapEntry
+
+func (x heapQueue) Len() int           { return len(x) }
+func (x heapQueue) Less(i, j int) bool { return x[i].expireAt < x[j].expireAt }
+
+func (x heapQueue) Swap(i, j int) {
+	x[i], x[j] = x[j], x[i]
+	x[i].index = i
+	x[j].index = j
+}
+
+func (x *heapQueue) Push(v interface{}) {
+	entry := v.(*heapEntry)
+	entry.index = len(*x)
+	*x = append(*x, entry)
+}
+
+func (x *heapQueue) Pop() interface{} {
+	old := *x
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	entry.index = -1
+	*x = old[:len(old)-1]
+	return entry
+}
@@ -0,0 +1,93 @@
+package lrumap_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/m-mizutani/lrumap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeapPutGetPrune(t *testing.T) {
+	lru := lrumap.NewHeap()
+	key1, key2, key3 := []byte("a"), []byte("b"), []byte("c")
+	assert.Nil(t, lru.Put(&testData{data: key1}, 1000000))
+	assert.Nil(t, lru.Put(&testData{data: key2}, 2))
+	assert.Nil(t, lru.Put(&testData{data: key3}, 0))
+	assert.Equal(t, lrumap.ErrDuplicateKey, lru.Put(&testData{data: key2}, 5))
+	assert.Equal(t, 3, lru.Size())
+
+	assert.NotNil(t, lru.Get(&key1))
+	assert.Equal(t, 0, len(*lru.Prune(2)))
+	pruned := lru.Prune(1)
+	assert.Equal(t, 1, len(*pruned))
+	assert.Equal(t, key2, *(*pruned)[0].Key())
+	assert.Nil(t, lru.Get(&key2))
+
+	// Data object with unbounded TTL is pruned at its tick
+	assert.Equal(t, 0, len(*lru.Prune(999997)))
+	assert.Equal(t, 1, len(*lru.Prune(1)))
+	assert.Equal(t, 1, lru.Size())
+	assert.Equal(t, 1000001, int(lru.CurrentTick()))
+
+	// Persistent data object is only removed by Delete
+	assert.NotNil(t, lru.Delete(&key3))
+	assert.Nil(t, lru.Delete(&key3))
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestHeapPruneOrder(t *testing.T) {
+	lru := lrumap.NewHeap()
+	assert.Nil(t, lru.Put(&testData{data: []byte("k3")}, 3))
+	assert.Nil(t, lru.Put(&testData{data: []byte("k1")}, 1))
+	assert.Nil(t, lru.Put(&testData{data: []byte("k2")}, 2))
+	key := []byte("k2")
+	assert.NotNil(t, lru.Delete(&key))
+
+	pruned := lru.Prune(10)
+	assert.Equal(t, 2, len(*pruned))
+	assert.Equal(t, "k1", string(*(*pruned)[0].Key()))
+	assert.Equal(t, "k3", string(*(*pruned)[1].Key()))
+}
+
+func TestHeapPruneMutatedKey(t *testing.T) {
+	lru := lrumap.NewHeap()
+	data := &testData{data: []byte("k1")}
+	assert.Nil(t, lru.Put(data, 1))
+	data.data[0] = 'x'
+
+	assert.Equal(t, 1, len(*lru.Prune(2)))
+	assert.Equal(t, 0, lru.Size())
+}
+
+func benchmarkLargeTTL(b *testing.B, put func(obj lrumap.LruData) error, prune func()) {
+	var data []testData
+	for i := 0; i < 1024; i++ {
+		data = append(data, testData{data: []byte(fmt.Sprintf("key%d", i))})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range data {
+			if err := put(&data[j]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		prune()
+	}
+}
+
+func BenchmarkWheelLargeMaxTick(b *testing.B) {
+	lru := lrumap.New(1 << 20)
+	benchmarkLargeTTL(b,
+		func(obj lrumap.LruData) error { return lru.Put(obj, 1<<20) },
+		func() { lru.PruneCount(1<<20 + 1) })
+}
+
+func BenchmarkHeapLargeMaxTick(b *testing.B) {
+	lru := lrumap.NewHeap()
+	benchmarkLargeTTL(b,
+		func(obj lrumap.LruData) error { return lru.Put(obj, 1<<20) },
+		func() { lru.Prune(1<<20 + 1) })
+}
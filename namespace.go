@@ -0,0 +1,75 @@
+package lrumap
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Namespace is a handle of LruMap to access data objects whose keys are
+// prefixed by the namespace. Keys in LruMap table are length of the prefix,
+// the prefix and the original key, so that keys of namespaces never collide
+// even if one prefix is a prefix of another. Key() of data object put via
+// Namespace keeps the original key.
+type Namespace struct {
+	lru    *LruMap
+	prefix []byte
+}
+
+// Namespace returns handle of the namespace that has prefix. The prefix is
+// copied.
+func (x *LruMap) Namespace(prefix []byte) *Namespace {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(prefix)))
+	return &Namespace{
+		lru:    x,
+		prefix: append(append([]byte(nil), buf[:n]...), prefix...),
+	}
+}
+
+func (x *Namespace) key(key []byte) []byte {
+	return append(append(make([]byte, 0, len(x.prefix)+len(key)), x.prefix...), key...)
+}
+
+// Put inserts data object into the namespace. See LruMap.Put.
+func (x *Namespace) Put(obj LruData, ttl tick) error {
+	k := x.key(*obj.Key())
+	return x.lru.put(&k, obj, ttl, false)
+}
+
+// Get returns data object of the key in the namespace if exists.
+func (x *Namespace) Get(key *[]byte) LruData {
+	k := x.key(*key)
+	return x.lru.Get(&k)
+}
+
+// Update replaces data object of the key in the namespace in place. See
+// LruMap.Update.
+func (x *Namespace) Update(key *[]byte, mutate func(LruData) LruData) bool {
+	k := x.key(*key)
+	return x.lru.Update(&k, mutate)
+}
+
+// Delete removes data object of the key in the namespace and returns it if
+// exists.
+func (x *Namespace) Delete(key *[]byte) LruData {
+	k := x.key(*key)
+	return x.lru.Delete(&k)
+}
+
+// Clear removes all data objects of the namespace and returns number of
+// removed data objects. Removed data objects are handled as Delete. Clear
+// scans all keys of LruMap table.
+func (x *Namespace) Clear() int {
+	var targets []*node
+	x.lru.walk(func(n *node) bool {
+		if bytes.HasPrefix(n.key, x.prefix) {
+			targets = append(targets, n)
+		}
+		return true
+	})
+
+	for _, n := range targets {
+		x.lru.deleteNode(n)
+	}
+	return len(targets)
+}
@@ -0,0 +1,60 @@
+package lrumap_test
+
+import (
+	"testing"
+
+	"github.com/m-mizutani/lrumap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespace(t *testing.T) {
+	lru := lrumap.New(12)
+	users := lru.Namespace([]byte("user"))
+	groups := lru.Namespace([]byte("group"))
+	key := []byte("abc")
+
+	user, group := &testData{data: key}, &testData{data: key}
+	assert.Nil(t, users.Put(user, 2))
+	assert.Nil(t, groups.Put(group, 2))
+	assert.Equal(t, lrumap.ErrDuplicateKey, users.Put(&testData{data: key}, 2))
+	assert.Equal(t, 2, lru.Size())
+	assert.Nil(t, lru.Get(&key))
+
+	assert.True(t, users.Get(&key) == user)
+	assert.True(t, groups.Get(&key) == group)
+
+	assert.NotNil(t, users.Delete(&key))
+	assert.Nil(t, users.Get(&key))
+	assert.NotNil(t, groups.Get(&key))
+
+	// Prefix of another prefix does not collide
+	a := lru.Namespace([]byte("a"))
+	ab := lru.Namespace([]byte("ab"))
+	keyBC, keyC := []byte("bc"), []byte("c")
+	assert.Nil(t, a.Put(&testData{data: keyBC}, 2))
+	assert.Nil(t, ab.Put(&testData{data: keyC}, 2))
+	assert.Nil(t, a.Get(&keyC))
+	assert.Nil(t, ab.Get(&keyBC))
+}
+
+func TestNamespaceClear(t *testing.T) {
+	var deleted []string
+	lru := lrumap.New(12, lrumap.WithEvictOnDelete(), lrumap.WithOnEvict(func(d lrumap.LruData) {
+		deleted = append(deleted, string(*d.Key()))
+	}))
+	users := lru.Namespace([]byte("user"))
+	groups := lru.Namespace([]byte("group"))
+	keyA, keyB := []byte("a"), []byte("b")
+
+	assert.Nil(t, users.Put(&testData{data: keyA}, 2))
+	assert.Nil(t, users.Put(&testData{data: keyB}, 0))
+	assert.Nil(t, groups.Put(&testData{data: keyA}, 2))
+	assert.Nil(t, lru.Put(&testData{data: []byte("user")}, 2))
+
+	assert.Equal(t, 2, users.Clear())
+	assert.Equal(t, 2, len(deleted))
+	assert.Equal(t, 2, lru.Size())
+	assert.Nil(t, users.Get(&keyA))
+	assert.NotNil(t, groups.Get(&keyA))
+	assert.Equal(t, 0, users.Clear())
+}
@@ -0,0 +1,181 @@
+package lrumap
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Option is a functional option of New to configure LruMap.
+type Option func(x *LruMap)
+
+// WithSlidingExpiration enables sliding expiration mode. In the mode,
+// successful Get refreshes TTL of the data object with the original TTL
+// given by Put. By default, data object expires on fixed schedule.
+func WithSlidingExpiration() Option {
+	return func(x *LruMap) {
+		x.sliding = true
+	}
+}
+
+// WithOnEvict registers a callback that is called with each data object
+// pruned by Prune. The callback is not called for Delete unless
+// WithEvictOnDelete is also given.
+func WithOnEvict(callback func(LruData)) Option {
+	return func(x *LruMap) {
+		x.onEvict = callback
+	}
+}
+
+// WithOnEvictReason registers a callback that is called with each data
+// object removed by Prune, Delete and the limits of WithMaxEntries and
+// WithMaxWeight together with the reason of removal.
+func WithOnEvictReason(callback func(LruData, EvictReason)) Option {
+	return func(x *LruMap) {
+		x.onEvictReason = callback
+	}
+}
+
+// WithEvictOnDelete makes Delete call the callback registered by WithOnEvict
+// for the removed data object.
+func WithEvictOnDelete() Option {
+	return func(x *LruMap) {
+		x.evictOnDelete = true
+	}
+}
+
+// WithMaxEntries limits number of data objects in LruMap. When Put inserts
+// a data object over the limit, the least recently used data object in Put,
+// GetOrPut, Upsert and Get is evicted and passed to the callback registered
+// by WithOnEvict. For ShardedLruMap, the limit is applied to each shard.
+func WithMaxEntries(n int) Option {
+	return func(x *LruMap) {
+		x.maxEntries = n
+	}
+}
+
+// WithMaxWeight limits total weight of data objects in LruMap. Weight of
+// data object is given by Weighted interface, and it is 1 if the data object
+// does not implement Weighted. When total weight exceeds n, data objects
+// nearest to expiration are evicted and passed to the callback registered by
+// WithOnEvict. Among data objects expiring at the same tick, the one
+// scheduled most recently is evicted first, but the data object being put
+// is never evicted by its own Put. Data objects that never expire are
+// evicted only if there is no other data object. Data object whose weight
+// is over n is never stored: Put and Upsert return ErrTooHeavy, GetOrPut
+// does not insert it, CompareAndSwap and Update return false, and Merge
+// skips it and returns ErrTooHeavy.
+func WithMaxWeight(n int) Option {
+	return func(x *LruMap) {
+		x.maxWeight = n
+	}
+}
+
+// WithHasher replaces hash function of key. Default hash function is FNV-1a.
+// Keys having the same hash value are chained in one bucket.
+func WithHasher(hasher func(key *[]byte) uint64) Option {
+	return func(x *LruMap) {
+		x.hash = func(key *[]byte) hashValue {
+			return hashValue(hasher(key))
+		}
+	}
+}
+
+// WithKeyEqual replaces comparison of keys in the table. By default, keys
+// are compared by bytes.Equal. Keys regarded as equal by equal must have the
+// same hash value, then hash function should be also given by WithHasher.
+func WithKeyEqual(equal func(a, b *[]byte) bool) Option {
+	return func(x *LruMap) {
+		x.keyEqual = equal
+	}
+}
+
+// WithMaxChainLength limits number of keys in one bucket. Put returns
+// ErrChainTooLong if a new key has the same hash value as n keys in the
+// table, then a developer can notice poor hash function given by
+// WithHasher. By default, number of keys in a bucket is not limited.
+func WithMaxChainLength(n int) Option {
+	return func(x *LruMap) {
+		x.maxChainLength = n
+	}
+}
+
+// WithInitialCapacity preallocates the table for n keys to avoid rehashing
+// while the table grows. Clear also keeps the capacity.
+func WithInitialCapacity(n int) Option {
+	return func(x *LruMap) {
+		x.capacity = n
+		x.table = make(map[hashValue]*bucket, n)
+	}
+}
+
+// WithJitter adds random offset in range of [0, max] to TTL of data object
+// stored with relative TTL to spread expiration of data objects put with the
+// same TTL over frames. It is applied by Put, PutWithOptions, Upsert,
+// PutBatch, PutString, PutRaw, Namespace.Put, GetOrPut, GetOrCompute and
+// new counter of AddInt. PutAt, PutWithIdle, SetTTL, CompareAndSwap, Touch,
+// Merge and restoring a snapshot keep the given or remaining TTL as is. TTL with the offset is capped at maxTick, and data
+// object with ttl 0 still never expires.
+func WithJitter(max tick) Option {
+	return func(x *LruMap) {
+		x.jitter = max
+	}
+}
+
+// WithJitterSource replaces random source of WithJitter. src must return a
+// value in range of [0, n). Default source is math/rand.
+func WithJitterSource(src func(n uint64) uint64) Option {
+	return func(x *LruMap) {
+		x.jitterSource = src
+	}
+}
+
+// randJitter returns random value in range of [0, n). n 0 means range of
+// all uint64 values because maxTick+1 overflows.
+func randJitter(n uint64) uint64 {
+	if n == 0 || n > math.MaxInt64 {
+		v := rand.Uint64()
+		if n > 0 {
+			v %= n
+		}
+		return v
+	}
+	return uint64(rand.Int63n(int64(n)))
+}
+
+// WithClampTTL makes Put, PutWithOptions, Upsert, GetOrPut, SetTTL and
+// CompareAndSwap cap TTL over maxTick at maxTick instead of rejecting it. By
+// default, TTL over maxTick is rejected with ErrTTLTooLarge or false.
+func WithClampTTL() Option {
+	return func(x *LruMap) {
+		x.clampTTL = true
+	}
+}
+
+// WithSecondaryIndex maintains index of data objects by attribute returned
+// by extract, then DeleteBySecondary can remove all data objects having the
+// same attribute such as sessions of a user. extract is called when data
+// object is put or replaced.
+func WithSecondaryIndex(extract func(LruData) string) Option {
+	return func(x *LruMap) {
+		x.secondary = extract
+		x.secondaryIndex = map[string]map[*node]struct{}{}
+	}
+}
+
+// WithSparseFrames stores only frames that have data objects in a map
+// instead of allocating all maxTick+1 frames on New. It saves memory for a
+// large maxTick with few data objects, but Put and Prune get slower by map
+// access.
+func WithSparseFrames() Option {
+	return func(x *LruMap) {
+		x.sparse = map[tick]*frame{}
+	}
+}
+
+// WithName sets name of LruMap to distinguish instances in Metrics. The name
+// is only informational and does not change behavior of LruMap.
+func WithName(name string) Option {
+	return func(x *LruMap) {
+		x.name = name
+	}
+}
@@ -0,0 +1,33 @@
+package lrumap
+
+// rawEntry wraps a value stored by PutRaw as LruData.
+type rawEntry struct {
+	key []byte
+	val interface{}
+}
+
+func (x *rawEntry) Key() *[]byte {
+	return &x.key
+}
+
+// PutRaw inserts val with key into LruMap table without implementing
+// LruData. The key is copied, so the caller can reuse the byte slice after
+// PutRaw returns. LruMap does not allow to insert value with duplicated key.
+func (x *LruMap) PutRaw(key []byte, val interface{}, ttl tick) error {
+	entry := &rawEntry{
+		key: append([]byte(nil), key...),
+		val: val,
+	}
+	return x.Put(entry, ttl)
+}
+
+// GetRaw returns value of the key inserted by PutRaw. The bool value is
+// false if the key does not exist or data object of the key was not
+// inserted by PutRaw.
+func (x *LruMap) GetRaw(key []byte) (interface{}, bool) {
+	entry, ok := x.Get(&key).(*rawEntry)
+	if !ok {
+		return nil, false
+	}
+	return entry.val, true
+}
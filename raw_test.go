@@ -0,0 +1,38 @@
+package lrumap_test
+
+import (
+	"testing"
+
+	"github.com/m-mizutani/lrumap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawValue(t *testing.T) {
+	type payload struct {
+		Name  string
+		Count int
+	}
+
+	lru := lrumap.New(12)
+	key := []byte("abc")
+	assert.Nil(t, lru.PutRaw(key, payload{Name: "blue", Count: 5}, 2))
+	assert.Equal(t, lrumap.ErrDuplicateKey, lru.PutRaw(key, payload{}, 2))
+
+	// Key is copied by PutRaw
+	key[0] = 'x'
+	val, ok := lru.GetRaw([]byte("abc"))
+	assert.True(t, ok)
+	assert.Equal(t, payload{Name: "blue", Count: 5}, val)
+	_, ok = lru.GetRaw(key)
+	assert.False(t, ok)
+
+	// Data object not inserted by PutRaw
+	other := []byte("other")
+	assert.Nil(t, lru.Put(&testData{data: other}, 2))
+	_, ok = lru.GetRaw(other)
+	assert.False(t, ok)
+
+	assert.Equal(t, 2, len(*lru.Prune(3)))
+	_, ok = lru.GetRaw([]byte("abc"))
+	assert.False(t, ok)
+}
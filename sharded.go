@@ -0,0 +1,77 @@
+package lrumap
+
+// ShardedLruMap is a thread safe map that distributes data objects across
+// multiple shards by hash value of key. Each shard is independent
+// SyncLruMap having own lock, so it reduces lock contention under high
+// concurrency compared with a single SyncLruMap.
+type ShardedLruMap struct {
+	shards []*SyncLruMap
+}
+
+// NewSharded is a constructor of ShardedLruMap. shardNum is number of
+// shards and regarded as 1 if it is less than 1. maxTick and options are
+// applied to every shard.
+func NewSharded(shardNum int, maxTick tick, options ...Option) *ShardedLruMap {
+	if shardNum < 1 {
+		shardNum = 1
+	}
+
+	shards := make([]*SyncLruMap, shardNum)
+	for i := range shards {
+		shards[i] = NewSync(maxTick, options...)
+	}
+	return &ShardedLruMap{shards: shards}
+}
+
+// Put inserts data object into a shard selected by the key.
+func (x *ShardedLruMap) Put(obj LruData, ttl tick) error {
+	return x.getShard(obj.Key()).Put(obj, ttl)
+}
+
+// TryPut inserts data object into a shard selected by the key without
+// waiting for the lock of the shard. false means that Put was not attempted
+// because the shard is locked by another goroutine. See SyncLruMap.TryPut.
+func (x *ShardedLruMap) TryPut(obj LruData, ttl tick) (bool, error) {
+	return x.getShard(obj.Key()).TryPut(obj, ttl)
+}
+
+// Get returns data object if exists.
+func (x *ShardedLruMap) Get(key *[]byte) LruData {
+	return x.getShard(key).Get(key)
+}
+
+// Contains returns true if data object with the key exists.
+func (x *ShardedLruMap) Contains(key *[]byte) bool {
+	return x.getShard(key).Contains(key)
+}
+
+// Delete removes data object and returns it if exists.
+func (x *ShardedLruMap) Delete(key *[]byte) LruData {
+	return x.getShard(key).Delete(key)
+}
+
+// Prune updates current tick of all shards and returns pruned data objects
+// of all shards.
+func (x *ShardedLruMap) Prune(progress tick) *[]LruData {
+	var res []LruData
+	for _, shard := range x.shards {
+		res = append(res, (*shard.Prune(progress))...)
+	}
+	return &res
+}
+
+// Size returns total number of data objects across all shards. Shards are
+// counted one by one, then the result may not be consistent with concurrent
+// modification.
+func (x *ShardedLruMap) Size() int {
+	total := 0
+	for _, shard := range x.shards {
+		total += shard.Size()
+	}
+	return total
+}
+
+func (x *ShardedLruMap) getShard(key *[]byte) *SyncLruMap {
+	hv := fnvHash(key)
+	return x.shards[hv%hashValue(len(x.shards))]
+}
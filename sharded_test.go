@@ -0,0 +1,78 @@
+package lrumap_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/m-mizutani/lrumap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedBasicScenario(t *testing.T) {
+	lru := lrumap.NewSharded(4, 12)
+
+	var keys [][]byte
+	for i := 0; i < 32; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		keys = append(keys, key)
+		assert.Nil(t, lru.Put(&testData{data: key}, 2))
+	}
+	assert.NotNil(t, lru.Put(&testData{data: keys[0]}, 2))
+	assert.Equal(t, 32, lru.Size())
+
+	for i := range keys {
+		assert.NotNil(t, lru.Get(&keys[i]))
+		assert.True(t, lru.Contains(&keys[i]))
+	}
+
+	assert.NotNil(t, lru.Delete(&keys[0]))
+	assert.False(t, lru.Contains(&keys[0]))
+	assert.Equal(t, 31, lru.Size())
+
+	assert.Equal(t, 0, len(*lru.Prune(2)))
+	assert.Equal(t, 31, len(*lru.Prune(1)))
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestShardedTryPut(t *testing.T) {
+	lru := lrumap.NewSharded(4, 12)
+	key := []byte("abc")
+	ok, err := lru.TryPut(&testData{data: key}, 2)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.True(t, lru.Contains(&key))
+
+	ok, err = lru.TryPut(&testData{data: key}, 2)
+	assert.True(t, ok)
+	assert.Equal(t, lrumap.ErrDuplicateKey, err)
+}
+
+func benchmarkParallelGet(b *testing.B, put func(lrumap.LruData) error, get func(*[]byte) lrumap.LruData) {
+	var keys [][]byte
+	for i := 0; i < 1024; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		keys = append(keys, key)
+		if err := put(&testData{data: key}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			get(&keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkSyncGet(b *testing.B) {
+	lru := lrumap.NewSync(12, lrumap.WithSlidingExpiration())
+	benchmarkParallelGet(b, func(d lrumap.LruData) error { return lru.Put(d, 10) }, lru.Get)
+}
+
+func BenchmarkShardedGet(b *testing.B) {
+	lru := lrumap.NewSharded(16, 12, lrumap.WithSlidingExpiration())
+	benchmarkParallelGet(b, func(d lrumap.LruData) error { return lru.Put(d, 10) }, lru.Get)
+}
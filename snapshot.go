@@ -0,0 +1,168 @@
+package lrumap
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var snapshotMagic = [4]byte{'L', 'R', 'U', 'M'}
+
+const snapshotVersion uint32 = 1
+
+// snapshotPersistent is remaining TTL of data object that never expires.
+const snapshotPersistent = ^uint64(0)
+
+type snapshotHeader struct {
+	Magic   [4]byte
+	Version uint32
+	MaxTick uint64
+	Current uint64
+	Count   uint64
+}
+
+// Snapshot writes all data objects in LruMap table with their remaining TTL
+// to w. Every data object must implement encoding.BinaryMarshaler, and the
+// marshaled bytes are passed to decoder of Restore.
+func (x *LruMap) Snapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	hdr := snapshotHeader{
+		Magic:   snapshotMagic,
+		Version: snapshotVersion,
+		MaxTick: uint64(x.maxTick),
+		Current: uint64(x.current),
+		Count:   uint64(x.Size()),
+	}
+	if err := binary.Write(bw, binary.BigEndian, &hdr); err != nil {
+		return err
+	}
+
+	var err error
+	x.walk(func(n *node) bool {
+		err = writeSnapshotEntry(bw, n, x.current)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+func writeSnapshotEntry(w io.Writer, n *node, current tick) error {
+	marshaler, ok := n.data.(encoding.BinaryMarshaler)
+	if !ok {
+		return fmt.Errorf("Data object of key %q does not implement encoding.BinaryMarshaler", n.key)
+	}
+	value, err := marshaler.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	remain := snapshotRemain(n, current)
+	if err := binary.Write(w, binary.BigEndian, remain); err != nil {
+		return err
+	}
+	if err := writeSnapshotBytes(w, n.key); err != nil {
+		return err
+	}
+	return writeSnapshotBytes(w, value)
+}
+
+// snapshotRemain returns remaining TTL of the node from current tick, or
+// snapshotPersistent if the node never expires.
+func snapshotRemain(n *node, current tick) uint64 {
+	if n.ttl == 0 {
+		return snapshotPersistent
+	}
+	if n.expireAt() > current {
+		return uint64(n.expireAt() - current)
+	}
+	return 0
+}
+
+func writeSnapshotBytes(w io.Writer, b []byte) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// Restore rebuilds LruMap from data written by Snapshot. decode converts
+// marshaled bytes of a data object back to LruData. Data objects are
+// restored under the keys in the original table, such as keys given by
+// PutString or Namespace. Current tick and maxTick are restored, then
+// remaining TTL of each data object is preserved.
+func Restore(r io.Reader, decode func([]byte) LruData, options ...Option) (*LruMap, error) {
+	br := bufio.NewReader(r)
+	var hdr snapshotHeader
+	if err := binary.Read(br, binary.BigEndian, &hdr); err != nil {
+		return nil, err
+	}
+	if hdr.Magic != snapshotMagic {
+		return nil, errors.New("Invalid snapshot format")
+	}
+	if hdr.Version != snapshotVersion {
+		return nil, fmt.Errorf("Unsupported snapshot version %d", hdr.Version)
+	}
+
+	lru := New(tick(hdr.MaxTick), options...)
+	lru.current = tick(hdr.Current)
+
+	for i := uint64(0); i < hdr.Count; i++ {
+		var remain uint64
+		if err := binary.Read(br, binary.BigEndian, &remain); err != nil {
+			return nil, err
+		}
+		key, err := readSnapshotBytes(br)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readSnapshotBytes(br)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := lru.restoreEntry(key, decode(value), remain); err != nil {
+			return nil, err
+		}
+	}
+
+	return lru, nil
+}
+
+// restoreEntry inserts restored data object with key in the original table
+// and remaining TTL given by snapshotRemain.
+func (x *LruMap) restoreEntry(key []byte, obj LruData, remain uint64) error {
+	if obj == nil {
+		return fmt.Errorf("Decoded data object of key %q is nil", key)
+	}
+
+	switch {
+	case remain == snapshotPersistent:
+		return x.insert(&key, obj, x.current, 0)
+	case remain > uint64(x.maxTick):
+		return fmt.Errorf("%w: remaining TTL %d of key %q", ErrTTLTooLarge, remain, key)
+	case remain == 0 && x.current == 0:
+		return fmt.Errorf("Invalid remaining TTL of key %q", key)
+	default:
+		return x.insertRemaining(&key, obj, tick(remain))
+	}
+}
+
+func readSnapshotBytes(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
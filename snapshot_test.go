@@ -0,0 +1,115 @@
+package lrumap_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/m-mizutani/lrumap"
+	"github.com/stretchr/testify/assert"
+)
+
+type snapshotData struct {
+	key   []byte
+	value string
+}
+
+func (x *snapshotData) Key() *[]byte {
+	return &x.key
+}
+
+func (x *snapshotData) MarshalBinary() ([]byte, error) {
+	return append(append(append([]byte{}, x.key...), 0), x.value...), nil
+}
+
+func decodeSnapshotData(b []byte) lrumap.LruData {
+	p := bytes.IndexByte(b, 0)
+	if p < 0 {
+		return nil
+	}
+	return &snapshotData{key: b[:p], value: string(b[p+1:])}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	lru := lrumap.New(12)
+	lru.Prune(3)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+	assert.Nil(t, lru.Put(&snapshotData{key: key1, value: "blue"}, 2))
+	assert.Nil(t, lru.Put(&snapshotData{key: key2, value: "orange"}, 7))
+	lru.Prune(1)
+
+	var buf bytes.Buffer
+	assert.Nil(t, lru.Snapshot(&buf))
+
+	restored, err := lrumap.Restore(&buf, decodeSnapshotData)
+	assert.Nil(t, err)
+	assert.Equal(t, lru.Size(), restored.Size())
+	assert.Equal(t, lru.CurrentTick(), restored.CurrentTick())
+	assert.Equal(t, lru.MaxTick(), restored.MaxTick())
+
+	for _, key := range [][]byte{key1, key2} {
+		expected, _ := lru.RemainingTTL(&key)
+		actual, ok := restored.RemainingTTL(&key)
+		assert.True(t, ok)
+		assert.Equal(t, expected, actual)
+	}
+	d, ok := restored.Get(&key2).(*snapshotData)
+	assert.True(t, ok)
+	assert.Equal(t, "orange", d.value)
+
+	assert.Equal(t, 1, len(*restored.Prune(2)))
+	assert.Equal(t, 1, len(*restored.Prune(5)))
+}
+
+func TestSnapshotNotMarshaler(t *testing.T) {
+	lru := lrumap.New(12)
+	assert.Nil(t, lru.Put(&testData{data: []byte("abc")}, 2))
+
+	var buf bytes.Buffer
+	assert.NotNil(t, lru.Snapshot(&buf))
+}
+
+func TestRestoreInvalidData(t *testing.T) {
+	_, err := lrumap.Restore(bytes.NewReader([]byte("invalid snapshot data")), decodeSnapshotData)
+	assert.NotNil(t, err)
+}
+
+func TestSnapshotRestoreZeroTTL(t *testing.T) {
+	lru := lrumap.New(12)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+	assert.Nil(t, lru.Put(&snapshotData{key: key1, value: "blue"}, 0))
+	assert.Nil(t, lru.Put(&snapshotData{key: key2, value: "orange"}, 3))
+	// key2 will be pruned in the next tick
+	lru.Prune(3)
+
+	var buf bytes.Buffer
+	assert.Nil(t, lru.Snapshot(&buf))
+
+	restored, err := lrumap.Restore(&buf, decodeSnapshotData)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, restored.Size())
+
+	pruned := restored.Prune(1)
+	assert.Equal(t, 1, len(*pruned))
+	assert.Equal(t, key2, *(*pruned)[0].Key())
+	assert.Equal(t, 0, len(*restored.Prune(12)))
+	assert.True(t, restored.Contains(&key1))
+}
+
+func TestSnapshotRestoreStoredKey(t *testing.T) {
+	lru := lrumap.New(12)
+	assert.Nil(t, lru.PutString("abc", &snapshotData{key: []byte("xyz"), value: "blue"}, 2))
+	ns := lru.Namespace([]byte("ns"))
+	key := []byte("a")
+	assert.Nil(t, ns.Put(&snapshotData{key: key, value: "orange"}, 3))
+
+	var buf bytes.Buffer
+	assert.Nil(t, lru.Snapshot(&buf))
+
+	restored, err := lrumap.Restore(&buf, decodeSnapshotData)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, restored.Size())
+	assert.Equal(t, "blue", restored.GetString("abc").(*snapshotData).value)
+	assert.Equal(t, "orange", restored.Namespace([]byte("ns")).Get(&key).(*snapshotData).value)
+}
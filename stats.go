@@ -0,0 +1,179 @@
+package lrumap
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Stats is a set of counters of LruMap operations.
+type Stats struct {
+	// Hits is number of Get calls that found data object.
+	Hits uint64
+	// Misses is number of Get calls that did not find data object.
+	Misses uint64
+	// Puts is number of data objects stored by Put, GetOrPut and Upsert.
+	Puts uint64
+	// Prunes is number of data objects removed by Prune.
+	Prunes uint64
+	// Evictions is number of data objects removed because number of data
+	// objects exceeds the limit of WithMaxEntries or total weight exceeds
+	// the limit of WithMaxWeight.
+	Evictions uint64
+}
+
+type counters struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	puts      atomic.Uint64
+	prunes    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// Stats returns current counters of LruMap operations. Counters are updated
+// atomically, so Stats can be called concurrently via SyncLruMap.
+func (x *LruMap) Stats() Stats {
+	return Stats{
+		Hits:      x.stats.hits.Load(),
+		Misses:    x.stats.misses.Load(),
+		Puts:      x.stats.puts.Load(),
+		Prunes:    x.stats.prunes.Load(),
+		Evictions: x.stats.evictions.Load(),
+	}
+}
+
+// ResetStats sets all counters of LruMap operations to zero.
+func (x *LruMap) ResetStats() {
+	x.stats.hits.Store(0)
+	x.stats.misses.Store(0)
+	x.stats.puts.Store(0)
+	x.stats.prunes.Store(0)
+	x.stats.evictions.Store(0)
+}
+
+// Stats returns current counters of LruMap operations without lock.
+func (x *SyncLruMap) Stats() Stats {
+	return x.lru.Stats()
+}
+
+// ResetStats sets all counters of LruMap operations to zero without lock.
+func (x *SyncLruMap) ResetStats() {
+	x.lru.ResetStats()
+}
+
+// Metrics is a set of gauges and counters of LruMap to be exported to a
+// monitoring system at once.
+type Metrics struct {
+	// Name is name of LruMap given by WithName.
+	Name string
+	// Size is number of data objects in the table.
+	Size int
+	// Buckets is number of occupied buckets.
+	Buckets int
+
+	// Counters of LruMap operations as same as Stats. Evictions includes
+	// data objects removed by both WithMaxEntries and WithMaxWeight.
+	Hits      uint64
+	Misses    uint64
+	Puts      uint64
+	Prunes    uint64
+	Evictions uint64
+}
+
+// Metrics returns current size, number of occupied buckets and counters of
+// LruMap operations. It does not walk the table, then it is cheap enough to
+// call periodically.
+func (x *LruMap) Metrics() Metrics {
+	stats := x.Stats()
+	return Metrics{
+		Name:      x.name,
+		Size:      x.Size(),
+		Buckets:   len(x.table),
+		Hits:      stats.Hits,
+		Misses:    stats.Misses,
+		Puts:      stats.Puts,
+		Prunes:    stats.Prunes,
+		Evictions: stats.Evictions,
+	}
+}
+
+// Metrics returns Metrics of LruMap with read lock.
+func (x *SyncLruMap) Metrics() Metrics {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	return x.lru.Metrics()
+}
+
+// BucketStats is a summary of buckets in LruMap table to check hash
+// collisions.
+type BucketStats struct {
+	// Buckets is number of occupied buckets.
+	Buckets int
+	// MaxChain is length of the longest chain of nodes in a bucket. Large
+	// value means keys collide and lookup works slowly.
+	MaxChain int
+	// Nodes is total number of nodes in all buckets.
+	Nodes int
+}
+
+// BucketStats walks all buckets and returns their summary.
+func (x *LruMap) BucketStats() BucketStats {
+	var stats BucketStats
+	for _, bkt := range x.table {
+		chain := 0
+		for p := bkt.root.next; p != nil; p = p.next {
+			chain++
+		}
+
+		stats.Buckets++
+		stats.Nodes += chain
+		if chain > stats.MaxChain {
+			stats.MaxChain = chain
+		}
+	}
+	return stats
+}
+
+// BucketFor returns all data objects in the bucket that has hash value of
+// the key, including data objects of other keys that collide with the key.
+// It returns empty slice if the bucket does not exist.
+func (x *LruMap) BucketFor(key *[]byte) []LruData {
+	var res []LruData
+	if bkt := x.table[x.hash(key)]; bkt != nil {
+		for p := bkt.root.next; p != nil; p = p.next {
+			res = append(res, p.data)
+		}
+	}
+	return res
+}
+
+// mapEntryOverhead is approximate bytes used by built-in map for an entry
+// besides its key and value, such as tophash and unused slots.
+const mapEntryOverhead = 16
+
+// ApproxMemoryBytes returns estimated bytes retained by LruMap: the frames,
+// the table and the secondary index, nodes and keys of data objects. Data
+// objects themselves are not counted. The estimate ignores allocator
+// overhead and walks all nodes, so call it for capacity planning rather than
+// frequently.
+func (x *LruMap) ApproxMemoryBytes() int {
+	var (
+		ptrSize    = int(unsafe.Sizeof(uintptr(0)))
+		frameSize  = int(unsafe.Sizeof(frame{}))
+		nodeSize   = int(unsafe.Sizeof(node{}))
+		bucketSize = int(unsafe.Sizeof(bucket{}))
+	)
+
+	size := int(unsafe.Sizeof(*x))
+	size += len(x.frames) * frameSize
+	size += len(x.sparse) * (int(unsafe.Sizeof(tick(0))) + ptrSize + frameSize + mapEntryOverhead)
+	size += len(x.table) * (int(unsafe.Sizeof(hashValue(0))) + ptrSize + bucketSize + mapEntryOverhead)
+
+	x.walk(func(n *node) bool {
+		size += nodeSize + cap(n.key)
+		return true
+	})
+	for attr, nodes := range x.secondaryIndex {
+		size += len(attr) + len(nodes)*(ptrSize+mapEntryOverhead)
+	}
+	return size
+}
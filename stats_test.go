@@ -0,0 +1,165 @@
+package lrumap_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/m-mizutani/lrumap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	lru := lrumap.New(12)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+	key3 := []byte("not found")
+	assert.Equal(t, lrumap.Stats{}, lru.Stats())
+
+	assert.Nil(t, lru.Put(&testData{data: key1}, 2))
+	assert.NotNil(t, lru.Put(&testData{data: key1}, 2))
+	assert.Nil(t, lru.Put(&testData{data: key2}, 5))
+	lru.Get(&key1)
+	lru.Get(&key1)
+	lru.Get(&key3)
+	lru.Prune(3)
+	lru.Get(&key1)
+
+	assert.Equal(t, lrumap.Stats{
+		Hits:   2,
+		Misses: 2,
+		Puts:   2,
+		Prunes: 1,
+	}, lru.Stats())
+
+	lru.ResetStats()
+	assert.Equal(t, lrumap.Stats{}, lru.Stats())
+}
+
+func TestSyncStats(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	key := []byte("abc")
+	assert.Nil(t, lru.Put(&testData{data: key}, 2))
+	lru.Get(&key)
+
+	assert.Equal(t, lrumap.Stats{Hits: 1, Puts: 1}, lru.Stats())
+	lru.ResetStats()
+	assert.Equal(t, lrumap.Stats{}, lru.Stats())
+}
+
+func TestBucketStats(t *testing.T) {
+	lru := lrumap.New(12)
+	assert.Equal(t, lrumap.BucketStats{}, lru.BucketStats())
+
+	assert.Nil(t, lru.Put(&testData{data: []byte("a")}, 2))
+	assert.Nil(t, lru.Put(&testData{data: []byte("b")}, 2))
+	assert.Equal(t, lrumap.BucketStats{Buckets: 2, MaxChain: 1, Nodes: 2}, lru.BucketStats())
+
+	// Keys starting with "x" collide
+	collide := lrumap.New(12, lrumap.WithHasher(func(key *[]byte) uint64 {
+		return uint64((*key)[0])
+	}))
+	for _, key := range []string{"x1", "x2", "x3", "y1"} {
+		assert.Nil(t, collide.Put(&testData{data: []byte(key)}, 2))
+	}
+	assert.Equal(t, lrumap.BucketStats{Buckets: 2, MaxChain: 3, Nodes: 4}, collide.BucketStats())
+}
+
+func TestMetrics(t *testing.T) {
+	lru := lrumap.New(12, lrumap.WithMaxEntries(2))
+	key1 := []byte("a")
+	key2 := []byte("b")
+	key3 := []byte("c")
+	assert.Equal(t, lrumap.Metrics{}, lru.Metrics())
+
+	assert.Nil(t, lru.Put(&testData{data: key1}, 2))
+	assert.Nil(t, lru.Put(&testData{data: key2}, 5))
+	assert.Nil(t, lru.Put(&testData{data: key3}, 5))
+	lru.Get(&key1)
+	lru.Get(&key2)
+	lru.Prune(6)
+
+	assert.Nil(t, lru.Put(&testData{data: key1}, 5))
+	assert.Equal(t, lrumap.Metrics{
+		Size:      1,
+		Buckets:   1,
+		Hits:      1,
+		Misses:    1,
+		Puts:      4,
+		Prunes:    2,
+		Evictions: 1,
+	}, lru.Metrics())
+	assert.Equal(t, uint64(1), lru.Stats().Evictions)
+
+	sync := lrumap.NewSync(12)
+	assert.Nil(t, sync.Put(&testData{data: key1}, 2))
+	assert.Equal(t, lrumap.Metrics{Size: 1, Buckets: 1, Puts: 1}, sync.Metrics())
+}
+
+func TestWithName(t *testing.T) {
+	lru := lrumap.New(12, lrumap.WithName("session"))
+	assert.Equal(t, "session", lru.Name())
+	assert.Equal(t, "", lrumap.New(12).Name())
+
+	assert.Nil(t, lru.Put(&testData{data: []byte("a")}, 2))
+	assert.Equal(t, lrumap.Metrics{Name: "session", Size: 1, Buckets: 1, Puts: 1}, lru.Metrics())
+	assert.Equal(t, "session", lru.Clone().Name())
+
+	sync := lrumap.NewSync(12, lrumap.WithName("user"))
+	assert.Equal(t, "user", sync.Name())
+	assert.Equal(t, "user", sync.Metrics().Name)
+}
+
+func TestBucketFor(t *testing.T) {
+	lru := lrumap.New(12)
+	key := []byte("a")
+	data := testData{data: key}
+	assert.Nil(t, lru.Put(&data, 2))
+	assert.Nil(t, lru.Put(&testData{data: []byte("b")}, 2))
+	assert.Equal(t, []lrumap.LruData{&data}, lru.BucketFor(&key))
+	missing := []byte("c")
+	assert.Empty(t, lru.BucketFor(&missing))
+
+	// Keys starting with "x" collide
+	collide := lrumap.New(12, lrumap.WithHasher(func(key *[]byte) uint64 {
+		return uint64((*key)[0])
+	}))
+	for _, k := range []string{"x1", "x2", "y1"} {
+		assert.Nil(t, collide.Put(&testData{data: []byte(k)}, 2))
+	}
+
+	var keys []string
+	for _, d := range collide.BucketFor(&missing) {
+		keys = append(keys, string(*d.Key()))
+	}
+	assert.Empty(t, keys)
+
+	probe := []byte("x9")
+	for _, d := range collide.BucketFor(&probe) {
+		keys = append(keys, string(*d.Key()))
+	}
+	assert.ElementsMatch(t, []string{"x1", "x2"}, keys)
+}
+
+func TestApproxMemoryBytes(t *testing.T) {
+	lru := lrumap.New(12)
+	empty := lru.ApproxMemoryBytes()
+	assert.True(t, empty > 0)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, lru.Put(&testData{data: []byte(fmt.Sprintf("key%d", i))}, 2))
+	}
+	small := lru.ApproxMemoryBytes()
+	assert.True(t, small > empty)
+
+	for i := 10; i < 100; i++ {
+		assert.Nil(t, lru.Put(&testData{data: []byte(fmt.Sprintf("key%d", i))}, 2))
+	}
+	large := lru.ApproxMemoryBytes()
+	assert.True(t, large > small)
+
+	// Roughly proportional to number of data objects
+	assert.True(t, large-empty > (small-empty)*5)
+
+	lru.Prune(3)
+	assert.Equal(t, empty, lru.ApproxMemoryBytes())
+}
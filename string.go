@@ -0,0 +1,21 @@
+package lrumap
+
+// PutString inserts data object with string key. The key is used instead of
+// Key() of the data object, and the data object can be looked up by both
+// GetString and Get with byte slice of the key.
+func (x *LruMap) PutString(key string, val LruData, ttl tick) error {
+	k := []byte(key)
+	return x.put(&k, val, ttl, false)
+}
+
+// GetString returns data object of string key if exists.
+func (x *LruMap) GetString(key string) LruData {
+	k := []byte(key)
+	return x.Get(&k)
+}
+
+// DeleteString removes data object of string key and returns it if exists.
+func (x *LruMap) DeleteString(key string) LruData {
+	k := []byte(key)
+	return x.Delete(&k)
+}
@@ -0,0 +1,27 @@
+package lrumap_test
+
+import (
+	"testing"
+
+	"github.com/m-mizutani/lrumap"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringKey(t *testing.T) {
+	lru := lrumap.New(12)
+	data := testData{data: []byte("value")}
+
+	assert.Nil(t, lru.PutString("abc", &data, 2))
+	assert.NotNil(t, lru.PutString("abc", &data, 2))
+	assert.Equal(t, 1, lru.Size())
+
+	assert.True(t, &data == lru.GetString("abc"))
+	key := []byte("abc")
+	assert.True(t, &data == lru.Get(&key))
+	assert.Nil(t, lru.GetString("value"))
+
+	assert.True(t, &data == lru.DeleteString("abc"))
+	assert.Nil(t, lru.GetString("abc"))
+	assert.Nil(t, lru.DeleteString("abc"))
+	assert.Equal(t, 0, lru.Size())
+}
@@ -0,0 +1,437 @@
+package lrumap
+
+import (
+	"sync"
+	"time"
+)
+
+// SyncLruMap is a thread safe wrapper of LruMap. Methods of SyncLruMap can be
+// called from multiple goroutines concurrently. Use LruMap directly for
+// single goroutine use to avoid locking cost. The callbacks registered by
+// WithOnEvict and WithOnEvictReason are called after releasing lock, so the
+// callbacks can access the table.
+type SyncLruMap struct {
+	lru   *LruMap
+	mutex sync.RWMutex
+
+	computeMutex sync.Mutex
+	computing    map[string]*computeCall
+}
+
+type computeCall struct {
+	wg  sync.WaitGroup
+	val LruData
+}
+
+// NewSync is a constructor of SyncLruMap. Arguments are same with New.
+func NewSync(maxTick tick, options ...Option) *SyncLruMap {
+	return newSyncLruMap(New(maxTick, options...))
+}
+
+// NewSyncWithClock is a constructor of SyncLruMap with time.Duration based
+// TTL. Arguments are same with NewWithClock.
+func NewSyncWithClock(resolution, maxTTL time.Duration, options ...Option) *SyncLruMap {
+	return newSyncLruMap(NewWithClock(resolution, maxTTL, options...))
+}
+
+func newSyncLruMap(lru *LruMap) *SyncLruMap {
+	lru.deferEvict = true
+	return &SyncLruMap{lru: lru}
+}
+
+// unlock releases write lock, then passes data objects removed under the
+// lock to the callbacks registered by WithOnEvict and WithOnEvictReason.
+// The callbacks can access the table, but may observe changes made after
+// the removal.
+func (x *SyncLruMap) unlock() {
+	pending := x.lru.takePendingEvicts()
+	x.mutex.Unlock()
+
+	for _, e := range pending {
+		x.lru.dispatchEvict(e.data, e.reason)
+	}
+}
+
+// Put inserts data object into the table. See LruMap.Put.
+func (x *SyncLruMap) Put(obj LruData, ttl tick) error {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.Put(obj, ttl)
+}
+
+// Upsert inserts or replaces data object with holding write lock. See
+// LruMap.Upsert.
+func (x *SyncLruMap) Upsert(obj LruData, ttl tick) error {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.Upsert(obj, ttl)
+}
+
+// GetOrPut returns existing data object of the same key as obj, or inserts
+// obj, with holding write lock, so that concurrent callers of the same key
+// share one data object. See LruMap.GetOrPut.
+func (x *SyncLruMap) GetOrPut(obj LruData, ttl tick) (LruData, bool) {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.GetOrPut(obj, ttl)
+}
+
+// PutWithIdle inserts data object with time-to-idle. See
+// LruMap.PutWithIdle.
+func (x *SyncLruMap) PutWithIdle(obj LruData, ttl, idle tick) error {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.PutWithIdle(obj, ttl, idle)
+}
+
+// TryPut works as Put, but returns false immediately without waiting if
+// the lock is held by another goroutine. false means that Put was not
+// attempted, and the error is always nil in that case. true means Put was
+// done and the error is the result of Put.
+func (x *SyncLruMap) TryPut(obj LruData, ttl tick) (bool, error) {
+	if !x.mutex.TryLock() {
+		return false, nil
+	}
+	defer x.unlock()
+	return true, x.lru.Put(obj, ttl)
+}
+
+// Get returns data object if exists. See LruMap.Get.
+func (x *SyncLruMap) Get(key *[]byte) LruData {
+	defer x.lockGet()()
+	return x.lru.Get(key)
+}
+
+// Lookup returns data object and true if the key exists. See
+// LruMap.Lookup.
+func (x *SyncLruMap) Lookup(key *[]byte) (LruData, bool) {
+	defer x.lockGet()()
+	return x.lru.Lookup(key)
+}
+
+// GetMulti returns data objects of keys with taking lock once for all keys.
+// See LruMap.GetMulti.
+func (x *SyncLruMap) GetMulti(keys []*[]byte) []LruData {
+	defer x.lockGet()()
+	return x.lru.GetMulti(keys)
+}
+
+// GetOrCompute returns data object of the key, or calls loader and inserts
+// the result on miss. See LruMap.GetOrCompute. loader is called without
+// holding the lock, so a slow loader does not block other operations.
+// Concurrent misses of the same key are deduplicated: only one goroutine
+// calls loader and others wait for and share its result.
+func (x *SyncLruMap) GetOrCompute(key *[]byte, ttl tick, loader func() LruData) LruData {
+	if d := x.Get(key); d != nil {
+		return d
+	}
+	return x.compute(key, ttl, loader)
+}
+
+// compute calls loader for the key missed by GetOrCompute unless another
+// goroutine is loading or has already stored the key.
+func (x *SyncLruMap) compute(key *[]byte, ttl tick, loader func() LruData) LruData {
+	k := string(*key)
+	x.computeMutex.Lock()
+	if c, ok := x.computing[k]; ok {
+		x.computeMutex.Unlock()
+		c.wg.Wait()
+		return c.val
+	}
+
+	// Another goroutine may store the key and finish loading after the miss
+	if d := x.peek(key); d != nil {
+		x.computeMutex.Unlock()
+		return d
+	}
+
+	c := &computeCall{}
+	c.wg.Add(1)
+	if x.computing == nil {
+		x.computing = map[string]*computeCall{}
+	}
+	x.computing[k] = c
+	x.computeMutex.Unlock()
+
+	defer func() {
+		x.computeMutex.Lock()
+		delete(x.computing, k)
+		x.computeMutex.Unlock()
+		c.wg.Done()
+	}()
+
+	obj := loader()
+	if obj == nil {
+		return nil
+	}
+
+	x.mutex.Lock()
+	defer x.unlock()
+	// Another goroutine may store the key while loader is running
+	if n := x.lru.lookup(key); n != nil {
+		c.val = n.data
+		return c.val
+	}
+	x.lru.put(key, obj, ttl, false)
+	c.val = obj
+	return c.val
+}
+
+// peek returns data object of the key without counting it in Stats nor
+// changing LRU order.
+func (x *SyncLruMap) peek(key *[]byte) LruData {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	if n := x.lru.lookup(key); n != nil {
+		return n.data
+	}
+	return nil
+}
+
+// Contains returns true if data object with the key exists.
+func (x *SyncLruMap) Contains(key *[]byte) bool {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	return x.lru.Contains(key)
+}
+
+// CompareAndSwap replaces data object of the key with holding write lock.
+// See LruMap.CompareAndSwap.
+func (x *SyncLruMap) CompareAndSwap(key *[]byte, expected, newData LruData, ttl tick) bool {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.CompareAndSwap(key, expected, newData, ttl)
+}
+
+// CompareAndSwapFunc replaces data object of the key with holding write
+// lock. See LruMap.CompareAndSwapFunc.
+func (x *SyncLruMap) CompareAndSwapFunc(key *[]byte, expected, newData LruData, ttl tick, equal func(current, expected LruData) bool) bool {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.CompareAndSwapFunc(key, expected, newData, ttl, equal)
+}
+
+// Touch reschedules data object of the key with holding write lock. See
+// LruMap.Touch.
+func (x *SyncLruMap) Touch(key *[]byte) bool {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.Touch(key)
+}
+
+// Update replaces data object of the key in place. See LruMap.Update.
+func (x *SyncLruMap) Update(key *[]byte, mutate func(LruData) LruData) bool {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.Update(key, mutate)
+}
+
+// Delete removes data object from the table and returns it. See
+// LruMap.Delete.
+func (x *SyncLruMap) Delete(key *[]byte) LruData {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.Delete(key)
+}
+
+// DeleteKeys removes data objects of keys with holding write lock once. See
+// LruMap.DeleteKeys.
+func (x *SyncLruMap) DeleteKeys(keys []*[]byte) int {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.DeleteKeys(keys)
+}
+
+// DeleteBySecondary removes data objects having the attribute with holding
+// write lock. See LruMap.DeleteBySecondary.
+func (x *SyncLruMap) DeleteBySecondary(attr string) int {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.DeleteBySecondary(attr)
+}
+
+// GetAndDelete returns data object and removes it with holding write lock,
+// so only one goroutine can take the data object. See LruMap.GetAndDelete.
+func (x *SyncLruMap) GetAndDelete(key *[]byte) LruData {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.GetAndDelete(key)
+}
+
+// Prune is update current tick and returns pruned data objects. See
+// LruMap.Prune. Prune(0) returns without lock.
+func (x *SyncLruMap) Prune(progress tick) *[]LruData {
+	if progress == 0 {
+		return &noPruned
+	}
+
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.Prune(progress)
+}
+
+// PruneExpired advances current tick by elapsed time and returns pruned
+// data objects. See LruMap.PruneExpired.
+func (x *SyncLruMap) PruneExpired() *[]LruData {
+	x.mutex.Lock()
+	defer x.unlock()
+	return x.lru.PruneExpired()
+}
+
+// Size returns number of data object in the table. Size does not take lock.
+func (x *SyncLruMap) Size() int {
+	return x.lru.Size()
+}
+
+// Keys returns copies of all keys in the table. It holds read lock while
+// walking the table, then it blocks writers until it returns. See
+// LruMap.Keys.
+func (x *SyncLruMap) Keys() [][]byte {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	return x.lru.Keys()
+}
+
+// Entries returns all data objects in the table. It blocks writers as Keys.
+// See LruMap.Entries.
+func (x *SyncLruMap) Entries() []LruData {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	return x.lru.Entries()
+}
+
+// ForEach calls fn for each data object in the table with holding read lock
+// for the full iteration, so writers are blocked until ForEach returns. fn
+// must not call methods of SyncLruMap that take write lock, or it
+// deadlocks. Use Entries to iterate data objects without holding lock. See
+// LruMap.ForEach.
+func (x *SyncLruMap) ForEach(fn func(LruData) bool) {
+	x.mutex.RLock()
+	defer x.mutex.RUnlock()
+	x.lru.ForEach(fn)
+}
+
+// Name returns name given by WithName. Name does not take lock.
+func (x *SyncLruMap) Name() string {
+	return x.lru.Name()
+}
+
+// Empty returns true if the table has no data object. Empty does not take
+// lock.
+func (x *SyncLruMap) Empty() bool {
+	return x.lru.Empty()
+}
+
+// lockGet takes read lock, or write lock if Get modifies the table, and
+// returns function to release the lock.
+func (x *SyncLruMap) lockGet() (unlock func()) {
+	if x.lru.mutableGet() {
+		x.mutex.Lock()
+		return x.unlock
+	}
+
+	x.mutex.RLock()
+	if x.lru.mutableGet() {
+		// PutWithIdle is called while waiting for read lock
+		x.mutex.RUnlock()
+		x.mutex.Lock()
+		return x.unlock
+	}
+	return x.mutex.RUnlock
+}
+
+// StartAutoPrune launches a goroutine that calls Prune(1) every interval.
+// Pruned data objects are passed to the callback registered by WithOnEvict.
+// The returned function stops the goroutine and waits for its exit.
+func (x *SyncLruMap) StartAutoPrune(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				x.Prune(1)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+		})
+	}
+}
+
+// StartAdaptivePrune launches a goroutine that calls PruneExpired with
+// interval adapted to the table. After pruning some data objects, the next
+// prune is after min to keep up with expiration. Otherwise, the goroutine
+// sleeps until the next data object expires, but at least min and at most
+// max. Empty table is checked every max, so data object put meanwhile may
+// be pruned up to max later than its TTL. StartAdaptivePrune is only for
+// SyncLruMap created by NewSyncWithClock, and returns stop function doing
+// nothing for others. The returned function stops the goroutine and waits
+// for its exit.
+func (x *SyncLruMap) StartAdaptivePrune(min, max time.Duration) (stop func()) {
+	if x.lru.resolution <= 0 {
+		return func() {}
+	}
+	if max < min {
+		max = min
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+
+	go func() {
+		defer close(exited)
+		interval := x.pruneInterval(min, max, 0)
+
+		for {
+			select {
+			case <-after(x.lru.clock, interval):
+				pruned := len(*x.PruneExpired())
+				interval = x.pruneInterval(min, max, pruned)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+		})
+	}
+}
+
+// pruneInterval returns the next interval of StartAdaptivePrune by
+// number of data objects pruned at the last time.
+func (x *SyncLruMap) pruneInterval(min, max time.Duration, pruned int) time.Duration {
+	if pruned > 0 {
+		return min
+	}
+
+	x.mutex.RLock()
+	d, ok := x.lru.nextPruneAfter()
+	x.mutex.RUnlock()
+
+	switch {
+	case !ok || d > max:
+		return max
+	case d < min:
+		return min
+	default:
+		return d
+	}
+}
@@ -0,0 +1,477 @@
+package lrumap_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m-mizutani/lrumap"
+	"github.com/m-mizutani/lrumap/clocktest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncConcurrentAccess(t *testing.T) {
+	lru := lrumap.NewSync(12, lrumap.WithSlidingExpiration())
+	var wg sync.WaitGroup
+
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := []byte(fmt.Sprintf("%d-%d", w, i))
+				assert.Nil(t, lru.Put(&testData{data: key}, 3))
+				if i%10 == 0 {
+					lru.Delete(&key)
+				}
+			}
+		}(w)
+	}
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := []byte(fmt.Sprintf("%d-%d", r, i))
+				lru.Get(&key)
+				lru.Contains(&key)
+				lru.Size()
+			}
+		}(r)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			lru.Prune(1)
+		}
+	}()
+
+	wg.Wait()
+	lru.Prune(12)
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestSyncAutoPrune(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	key := []byte("abc")
+	assert.Nil(t, lru.Put(&testData{data: key}, 2))
+
+	stop := lru.StartAutoPrune(time.Millisecond)
+	defer stop()
+
+	timeout := time.After(time.Second)
+	for lru.Contains(&key) {
+		select {
+		case <-timeout:
+			t.Fatal("data object is not pruned automatically")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	assert.Equal(t, 0, lru.Size())
+
+	// stop can be called multiple times
+	stop()
+	stop()
+}
+
+// waitTimer waits for the goroutine to sleep on the clock and returns
+// duration of the sleep.
+func waitTimer(t *testing.T, clock *clocktest.FakeClock) time.Duration {
+	timeout := time.After(time.Second)
+	for {
+		if d, ok := clock.NextTimer(); ok {
+			return d
+		}
+		select {
+		case <-timeout:
+			t.Fatal("goroutine does not wait on the clock")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestSyncAdaptivePrune(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	lru := lrumap.NewSyncWithClock(time.Second, time.Minute, lrumap.WithClock(clock))
+	stop := lru.StartAdaptivePrune(time.Second, 30*time.Second)
+	defer stop()
+
+	// Sleeps longest while empty
+	assert.Equal(t, 30*time.Second, waitTimer(t, clock))
+	assert.Nil(t, lru.Put(&testData{data: []byte("a")}, 3))
+	clock.Advance(30 * time.Second)
+
+	// Prunes again soon after pruning
+	assert.Equal(t, time.Second, waitTimer(t, clock))
+	assert.Equal(t, 0, lru.Size())
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, lru.Put(&testData{data: []byte(fmt.Sprint(i))}, 5))
+	}
+	clock.Advance(time.Second)
+
+	// Sleeps until the next expiration
+	assert.Equal(t, 5*time.Second, waitTimer(t, clock))
+	assert.Equal(t, 10, lru.Size())
+	clock.Advance(5 * time.Second)
+
+	assert.Equal(t, time.Second, waitTimer(t, clock))
+	assert.Equal(t, 0, lru.Size())
+	clock.Advance(time.Second)
+
+	assert.Equal(t, 30*time.Second, waitTimer(t, clock))
+
+	// Stop waits for exit, and stop can be called multiple times
+	stop()
+	stop()
+}
+
+func TestSyncAdaptivePruneWithoutClock(t *testing.T) {
+	stop := lrumap.NewSync(12).StartAdaptivePrune(time.Millisecond, time.Second)
+	stop()
+}
+
+func TestSyncIterationWithWriters(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := []byte(fmt.Sprintf("%d-%d", w, i))
+				lru.Put(&testData{data: key}, 3)
+				if i%3 == 0 {
+					lru.Delete(&key)
+				}
+				if i%50 == 0 {
+					lru.Prune(1)
+				}
+			}
+		}(w)
+	}
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				keys := lru.Keys()
+				entries := lru.Entries()
+				lru.ForEach(func(d lrumap.LruData) bool {
+					assert.NotNil(t, d.Key())
+					return true
+				})
+				for _, key := range keys {
+					assert.NotEqual(t, 0, len(key))
+				}
+				for _, d := range entries {
+					assert.NotNil(t, d)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	assert.Equal(t, lru.Size(), len(lru.Keys()))
+	assert.Equal(t, lru.Size(), len(lru.Entries()))
+}
+
+func TestSyncTryPut(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	key := []byte("abc")
+	ok, err := lru.TryPut(&testData{data: key}, 2)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	ok, err = lru.TryPut(&testData{data: key}, 2)
+	assert.True(t, ok)
+	assert.Equal(t, lrumap.ErrDuplicateKey, err)
+
+	// ForEach holds read lock during iteration
+	other := []byte("xyz")
+	lru.ForEach(func(lrumap.LruData) bool {
+		ok, err = lru.TryPut(&testData{data: other}, 2)
+		return true
+	})
+	assert.False(t, ok)
+	assert.Nil(t, err)
+	assert.False(t, lru.Contains(&other))
+	assert.Equal(t, 1, lru.Size())
+}
+
+func TestSyncGetMulti(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	key1 := []byte("abc")
+	key2 := []byte("xyz")
+	assert.Nil(t, lru.Put(&testData{data: key1}, 2))
+
+	res := lru.GetMulti([]*[]byte{&key1, &key2})
+	assert.NotNil(t, res[0])
+	assert.Nil(t, res[1])
+}
+
+func TestSyncSizeWithoutLock(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	assert.True(t, lru.Empty())
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := []byte(fmt.Sprintf("%d-%d", w, i))
+				assert.Nil(t, lru.Put(&testData{data: key}, 3))
+				if i%10 == 0 {
+					lru.Prune(1)
+				}
+			}
+		}(w)
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			n := lru.Size()
+			assert.True(t, n >= 0 && n <= 400)
+			lru.Empty()
+		}
+	}
+
+	lru.Prune(12)
+	assert.Equal(t, 0, lru.Size())
+	assert.True(t, lru.Empty())
+}
+
+func TestSyncGetAndDeleteClaim(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("job%d", i))
+		assert.Nil(t, lru.Put(&testData{data: key}, 5))
+
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		winners := 0
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if lru.GetAndDelete(&key) != nil {
+					mutex.Lock()
+					winners++
+					mutex.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, winners)
+	}
+	assert.Equal(t, 0, lru.Size())
+}
+
+func TestSyncGetOrComputeConcurrentMiss(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	key := []byte("abc")
+	var called int32
+	loader := func() lrumap.LruData {
+		atomic.AddInt32(&called, 1)
+		time.Sleep(20 * time.Millisecond)
+		return &testData{data: []byte("abc")}
+	}
+
+	var wg sync.WaitGroup
+	results := make([]lrumap.LruData, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = lru.GetOrCompute(&key, 5, loader)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&called))
+	for i := range results {
+		assert.True(t, results[0] == results[i])
+	}
+	assert.Equal(t, 1, lru.Size())
+
+	// Hit
+	assert.True(t, results[0] == lru.GetOrCompute(&key, 5, loader))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&called))
+}
+
+func TestSyncGetOrPutConcurrent(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	key := []byte("abc")
+
+	var wg sync.WaitGroup
+	var stored int32
+	results := make([]lrumap.LruData, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d, ok := lru.GetOrPut(&testData{data: []byte("abc")}, 5)
+			if ok {
+				atomic.AddInt32(&stored, 1)
+			}
+			results[i] = d
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&stored))
+	for i := range results {
+		assert.True(t, results[0] == results[i])
+	}
+	assert.True(t, results[0] == lru.Get(&key))
+	assert.Equal(t, 1, lru.Size())
+}
+
+func TestSyncUpsert(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	key := []byte("counter")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, lru.Upsert(&counterData{key: key, n: i}, 5))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, lru.Size())
+	assert.NotNil(t, lru.Get(&key))
+}
+
+func TestSyncCompareAndSwap(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	key := []byte("counter")
+	assert.Nil(t, lru.Put(&counterData{key: key}, 10))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for {
+					current := lru.Get(&key).(*counterData)
+					next := &counterData{key: key, n: current.n + 1}
+					if lru.CompareAndSwap(&key, current, next, 10) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 800, lru.Get(&key).(*counterData).n)
+}
+
+type counterData struct {
+	key []byte
+	n   int
+}
+
+func (x *counterData) Key() *[]byte { return &x.key }
+
+func TestSyncDeleteKeys(t *testing.T) {
+	lru := lrumap.NewSync(12)
+	key1, key2 := []byte("a"), []byte("b")
+	missing := []byte("x")
+	assert.Nil(t, lru.Put(&testData{data: key1}, 2))
+	assert.Nil(t, lru.Put(&testData{data: key2}, 2))
+
+	assert.Equal(t, 1, lru.DeleteKeys([]*[]byte{&key1, &missing}))
+	assert.Equal(t, 1, lru.Size())
+}
+
+func TestSyncPruneCallbackOutsideLock(t *testing.T) {
+	var lru *lrumap.SyncLruMap
+	var reinserted []string
+	lru = lrumap.NewSync(12, lrumap.WithOnEvict(func(data lrumap.LruData) {
+		// Re-entrant access must not deadlock
+		key := append([]byte("re:"), *data.Key()...)
+		assert.Nil(t, lru.Put(&testData{data: key}, 2))
+		reinserted = append(reinserted, string(*lru.Get(&key).Key()))
+	}))
+
+	assert.Nil(t, lru.Put(&testData{data: []byte("a")}, 1))
+	assert.Nil(t, lru.Put(&testData{data: []byte("b")}, 1))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Equal(t, 2, len(*lru.Prune(2)))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Prune is deadlocked")
+	}
+	assert.ElementsMatch(t, []string{"re:a", "re:b"}, reinserted)
+	assert.Equal(t, 2, lru.Size())
+}
+
+// withinSecond fails the test if fn does not return within a second.
+func withinSecond(t *testing.T, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("deadlocked")
+	}
+}
+
+func TestSyncEvictCallbackOutsideLock(t *testing.T) {
+	// Expiration by Get of SyncLruMap with clock
+	clock := clocktest.NewFakeClock(time.Now())
+	var lru *lrumap.SyncLruMap
+	var evicted []string
+	lru = lrumap.NewSyncWithClock(time.Second, 10*time.Second, lrumap.WithClock(clock),
+		lrumap.WithOnEvict(func(data lrumap.LruData) {
+			assert.Nil(t, lru.Get(data.Key()))
+			evicted = append(evicted, string(*data.Key()))
+		}))
+	key := []byte("a")
+	assert.Nil(t, lru.Put(&testData{data: key}, 1))
+	clock.Advance(3 * time.Second)
+	withinSecond(t, func() { assert.Nil(t, lru.Get(&key)) })
+	assert.Equal(t, []string{"a"}, evicted)
+
+	// Eviction by capacity
+	var capped *lrumap.SyncLruMap
+	var reasons []lrumap.EvictReason
+	capped = lrumap.NewSync(12, lrumap.WithMaxEntries(1),
+		lrumap.WithOnEvictReason(func(data lrumap.LruData, reason lrumap.EvictReason) {
+			assert.Equal(t, 1, len(capped.Keys()))
+			reasons = append(reasons, reason)
+		}))
+	assert.Nil(t, capped.Put(&testData{data: []byte("a")}, 2))
+	withinSecond(t, func() { assert.Nil(t, capped.Put(&testData{data: []byte("b")}, 2)) })
+	assert.Equal(t, []lrumap.EvictReason{lrumap.CapacityReason}, reasons)
+}
@@ -0,0 +1,79 @@
+package lrumap
+
+import "fmt"
+
+// Verify checks integrity of LruMap table. Each data object must be linked
+// to exactly one bucket and exactly one frame, and number of data objects
+// must be same as Size. It walks all buckets and frames, then it is for
+// debugging and should not be called in hot path.
+func (x *LruMap) Verify() error {
+	// Nodes are collected before checking links, so that a node in two
+	// chains is found regardless of order of buckets
+	buckets := make(map[*node]bool, x.Size())
+	for _, bkt := range x.table {
+		for p := bkt.root.next; p != nil; p = p.next {
+			if _, seen := buckets[p]; seen {
+				return fmt.Errorf("Key %q is linked to bucket chain twice", p.key)
+			}
+			buckets[p] = false
+		}
+	}
+
+	for hv, bkt := range x.table {
+		prev := &bkt.root
+		for p := bkt.root.next; p != nil; p = p.next {
+			if p.prev != prev {
+				return fmt.Errorf("Broken bucket chain at key %q", p.key)
+			}
+			if p.hv != hv {
+				return fmt.Errorf("Key %q is in bucket of another hash value", p.key)
+			}
+			prev = p
+		}
+	}
+
+	verifyFrame := func(f *frame) error {
+		var prev *node
+		for p := f.link; p != nil; p = p.frameLink {
+			if p.framePrev != prev {
+				return fmt.Errorf("Broken frame chain at key %q", p.key)
+			}
+			linked, ok := buckets[p]
+			if !ok {
+				return fmt.Errorf("Key %q in frame is not linked to any bucket", p.key)
+			}
+			if linked {
+				return fmt.Errorf("Key %q is linked to frames twice", p.key)
+			}
+			if x.frameOf(p) != f {
+				return fmt.Errorf("Key %q is linked to wrong frame", p.key)
+			}
+			buckets[p] = true
+			prev = p
+		}
+		return nil
+	}
+
+	var err error
+	x.eachFrame(func(f *frame) {
+		if err == nil {
+			err = verifyFrame(f)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if err := verifyFrame(&x.persistent); err != nil {
+		return err
+	}
+
+	for n, linked := range buckets {
+		if !linked {
+			return fmt.Errorf("Key %q is not linked to any frame", n.key)
+		}
+	}
+	if len(buckets) != x.Size() {
+		return fmt.Errorf("Size %d does not match number of data objects %d", x.Size(), len(buckets))
+	}
+	return nil
+}